@@ -0,0 +1,142 @@
+// Copyright (c) 2019,CAO HONGJU. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package scheduler
+
+import "time"
+
+// maxClampProbes bounds the times that a clamped schedule advances
+// its inner schedule.
+const maxClampProbes = 1 << 12
+
+// BusinessHours returns the schedule that fires at the times of inner,
+// which fall within the open hours [openHour:00, closeHour:00) on the given days.
+// When a time of inner is outside the open hours, the schedule jumps to the
+// next opening rather than stepping through inner. The days out of range
+// wrap around the week, e.g. 7 is Sunday. If days is empty,
+// all days are allowed. If loc is nil, the location of the time given
+// to Next is used.
+func BusinessHours(inner Schedule, openHour, closeHour int, days []time.Weekday, loc *time.Location) Schedule {
+	if openHour < 0 || closeHour > 24 || openHour >= closeHour {
+		return emptySchedule{}
+	}
+
+	bh := &businessHours{
+		inner: inner,
+		open:  openHour,
+		close: closeHour,
+		loc:   loc,
+	}
+	for _, day := range days {
+		bh.days[(int(day)%7+7)%7] = true // e.g. 7 and -7 are Sunday
+	}
+	if len(days) == 0 {
+		bh.days = [7]bool{true, true, true, true, true, true, true}
+	}
+	return bh
+}
+
+type businessHours struct {
+	inner       Schedule
+	open, close int
+	days        [7]bool
+	loc         *time.Location
+}
+
+func (bh *businessHours) Next(t time.Time) time.Time {
+	next := bh.inner.Next(t)
+	for i := 0; i < maxClampProbes && !next.IsZero(); i++ {
+		lt := next
+		if bh.loc != nil {
+			lt = next.In(bh.loc)
+		}
+		if bh.days[lt.Weekday()] && lt.Hour() >= bh.open && lt.Hour() < bh.close {
+			return next
+		}
+
+		// jump to the next opening
+		opening := bh.nextOpening(lt)
+		next = bh.inner.Next(opening.Add(-time.Nanosecond))
+	}
+	return time.Time{}
+}
+
+// nextOpening returns the next opening time after t, which is outside the open hours.
+func (bh *businessHours) nextOpening(t time.Time) time.Time {
+	y, m, d := t.Date()
+	if bh.days[t.Weekday()] && t.Hour() < bh.open {
+		return time.Date(y, m, d, bh.open, 0, 0, 0, t.Location())
+	}
+
+	for i := 1; i <= 7; i++ {
+		if bh.days[(int(t.Weekday())+i)%7] {
+			return time.Date(y, m, d+i, bh.open, 0, 0, 0, t.Location())
+		}
+	}
+	return time.Time{} // unreachable, there is at least one day
+}
+
+// maxBusinessDayOffset bounds the offset from the last business day,
+// every month has at least 20 business days.
+const maxBusinessDayOffset = 19
+
+// Quarterly returns the schedule that fires at the midnight of the last business
+// day (Monday-Friday) of each quarter, i.e. of March, June, September and December.
+// dayOffsetFromEnd moves the fires to the earlier business days, e.g. 1 is the second
+// last business day. If dayOffsetFromEnd is out of 0-19, the schedule is empty.
+// If loc is nil, the location of the time given to Next is used.
+func Quarterly(dayOffsetFromEnd int, loc *time.Location) Schedule {
+	if dayOffsetFromEnd < 0 || dayOffsetFromEnd > maxBusinessDayOffset {
+		return emptySchedule{}
+	}
+	return &monthEnd{months: 3, offset: dayOffsetFromEnd, loc: loc}
+}
+
+// FiscalMonthEnd returns the schedule that fires at the midnight of the last
+// business day (Monday-Friday) of each month. If loc is nil, the location of
+// the time given to Next is used.
+func FiscalMonthEnd(loc *time.Location) Schedule {
+	return &monthEnd{months: 1, loc: loc}
+}
+
+// monthEnd fires at the last business days of the months divisible by months.
+type monthEnd struct {
+	months int
+	offset int // the business days before the last one
+	loc    *time.Location
+}
+
+func (me *monthEnd) Next(t time.Time) time.Time {
+	lt := t
+	if me.loc != nil {
+		lt = t.In(me.loc)
+	}
+
+	y, m, _ := lt.Date()
+	for i := 0; i <= me.months; i++ { // the fire of this period may have passed
+		month := m + time.Month(i)
+		if int(month)%me.months != 0 {
+			continue
+		}
+		if next := me.lastBusinessDay(y, month, lt.Location()); next.After(t) {
+			return next.In(t.Location())
+		}
+	}
+	return time.Time{} // unreachable
+}
+
+// lastBusinessDay returns the midnight of the business day
+// the offset before the last one in the month.
+func (me *monthEnd) lastBusinessDay(year int, month time.Month, loc *time.Location) time.Time {
+	day := time.Date(year, month+1, 0, 0, 0, 0, 0, loc)
+	for offset := me.offset; ; day = time.Date(year, month, day.Day()-1, 0, 0, 0, 0, loc) {
+		if day.Weekday() == time.Saturday || day.Weekday() == time.Sunday {
+			continue
+		}
+		if offset == 0 {
+			return day
+		}
+		offset--
+	}
+}
@@ -0,0 +1,117 @@
+// Copyright (c) 2019,CAO HONGJU. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package scheduler
+
+import (
+	"testing"
+	"time"
+
+	"github.com/cnotch/scheduler/cron"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBusinessHours(t *testing.T) {
+	mon2fri := []time.Weekday{time.Monday, time.Tuesday, time.Wednesday, time.Thursday, time.Friday}
+	sched := BusinessHours(cron.MustParse("0 */15 * * * *"), 9, 17, mon2fri, time.UTC)
+
+	layout := "Mon 2006-01-02 15:04"
+	tests := []struct {
+		from string
+		next string
+	}{
+		{"Fri 2020-04-24 16:40", "Fri 2020-04-24 16:45"},
+		{"Fri 2020-04-24 16:45", "Mon 2020-04-27 09:00"},
+		{"Sat 2020-04-25 10:00", "Mon 2020-04-27 09:00"},
+		{"Tue 2020-04-28 08:00", "Tue 2020-04-28 09:00"},
+		{"Tue 2020-04-28 09:00", "Tue 2020-04-28 09:15"},
+		{"Tue 2020-04-28 23:59", "Wed 2020-04-29 09:00"},
+	}
+	for _, test := range tests {
+		from, _ := time.Parse(layout, test.from)
+		assert.Equal(t, test.next, sched.Next(from).Format(layout), test.from)
+	}
+
+	// the same as the intersection
+	hours := cron.MustParse("0 * 9-16 * * MON-FRI")
+	intersect := Intersect(cron.MustParse("0 */15 * * * *"), hours)
+	from := time.Date(2020, 4, 24, 16, 0, 0, 0, time.UTC)
+	t1, t2 := from, from
+	for i := 0; i < 100; i++ {
+		t1, t2 = sched.Next(t1), intersect.Next(t2)
+		assert.Equal(t, t2, t1)
+	}
+
+	// all days, and invalid hours
+	sched = BusinessHours(cron.MustParse("0 0 * * *"), 9, 17, nil, time.UTC)
+	assert.True(t, sched.Next(from).IsZero())
+	sched = BusinessHours(cron.MustParse("0 * * * *"), 17, 9, nil, time.UTC)
+	assert.True(t, sched.Next(from).IsZero())
+	sched = BusinessHours(cron.MustParse("0 * * * *"), 0, 24, nil, nil)
+	assert.Equal(t, from.Add(time.Hour), sched.Next(from))
+
+	// the days out of range wrap around the week
+	assert.NotPanics(t, func() {
+		sched = BusinessHours(cron.MustParse("0 0 * * *"), 0, 24, []time.Weekday{-1, 7}, time.UTC)
+	})
+	assert.Equal(t, "Sat 2020-04-25 00:00", sched.Next(from).Format(layout))
+	assert.Equal(t, "Sun 2020-04-26 00:00", sched.Next(sched.Next(from)).Format(layout))
+}
+
+func TestQuarterly(t *testing.T) {
+	var fires []string
+	sched := Quarterly(0, time.UTC)
+	next := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i := 0; i < 5; i++ {
+		next = sched.Next(next)
+		fires = append(fires, next.Format("Mon 2006-01-02 15:04"))
+	}
+	assert.Equal(t, []string{
+		"Tue 2020-03-31 00:00",
+		"Tue 2020-06-30 00:00",
+		"Wed 2020-09-30 00:00",
+		"Thu 2020-12-31 00:00",
+		"Wed 2021-03-31 00:00",
+	}, fires)
+
+	// the offset from the last business day
+	next = Quarterly(1, time.UTC).Next(time.Date(2021, 4, 1, 0, 0, 0, 0, time.UTC))
+	assert.Equal(t, time.Date(2021, 6, 29, 0, 0, 0, 0, time.UTC), next)
+	next = Quarterly(0, time.UTC).Next(time.Date(2021, 7, 1, 0, 0, 0, 0, time.UTC))
+	assert.Equal(t, time.Date(2021, 9, 30, 0, 0, 0, 0, time.UTC), next)
+	next = Quarterly(0, time.UTC).Next(time.Date(2022, 12, 1, 0, 0, 0, 0, time.UTC))
+	assert.Equal(t, time.Date(2022, 12, 30, 0, 0, 0, 0, time.UTC), next) // Dec 31 is Saturday
+	next = Quarterly(0, time.UTC).Next(time.Date(2022, 12, 30, 0, 0, 0, 0, time.UTC))
+	assert.Equal(t, time.Date(2023, 3, 31, 0, 0, 0, 0, time.UTC), next)
+
+	assert.True(t, Quarterly(20, time.UTC).Next(next).IsZero())
+	assert.True(t, Quarterly(-1, time.UTC).Next(next).IsZero())
+}
+
+func TestFiscalMonthEnd(t *testing.T) {
+	sched := FiscalMonthEnd(time.UTC)
+	tests := []struct {
+		from string
+		next string
+	}{
+		{"Wed 2020-01-01 00:00", "Fri 2020-01-31 00:00"},
+		{"Fri 2020-01-31 00:00", "Fri 2020-02-28 00:00"}, // Feb 29 is Saturday
+		{"Fri 2020-05-01 00:00", "Fri 2020-05-29 00:00"}, // May 31 is Sunday
+		{"Fri 2020-05-29 00:00", "Tue 2020-06-30 00:00"},
+		{"Thu 2020-12-31 00:00", "Fri 2021-01-29 00:00"},
+	}
+
+	layout := "Mon 2006-01-02 15:04"
+	for _, test := range tests {
+		from, _ := time.ParseInLocation(layout, test.from, time.UTC)
+		assert.Equal(t, test.next, sched.Next(from).Format(layout), test.from)
+	}
+
+	// the location of the time given to Next
+	shanghai := time.FixedZone("CST", 8*3600)
+	next := FiscalMonthEnd(nil).Next(time.Date(2020, 1, 31, 0, 0, 0, 0, shanghai))
+	assert.Equal(t, time.Date(2020, 2, 28, 0, 0, 0, 0, shanghai), next)
+	next = FiscalMonthEnd(shanghai).Next(time.Date(2020, 1, 30, 16, 0, 0, 0, time.UTC))
+	assert.Equal(t, time.Date(2020, 2, 27, 16, 0, 0, 0, time.UTC), next)
+}
@@ -0,0 +1,70 @@
+// Copyright (c) 2019,CAO HONGJU. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package scheduler
+
+import (
+	"container/heap"
+	"time"
+)
+
+// clockStepThreshold is the least difference between the elapsed wall clock
+// and monotonic time taken as a step of the wall clock.
+const clockStepThreshold = 100 * time.Millisecond
+
+// A Clock provides the time of a Scheduler.
+type Clock interface {
+	// Now returns the wall clock time, which may be stepped, e.g. by NTP.
+	Now() time.Time
+	// Monotonic returns the time elapsed since a fixed instant,
+	// which is never stepped.
+	Monotonic() time.Duration
+}
+
+var processStart = time.Now()
+
+// systemClock is the Clock of the system.
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+func (systemClock) Monotonic() time.Duration {
+	return time.Since(processStart)
+}
+
+// trackClock detects the steps of the wall clock, and shifts the next times
+// of the interval jobs by the steps, so their gaps follow the monotonic time.
+// The cron jobs keep following the wall clock.
+func (s *Scheduler) trackClock(jobs *jobQueue) {
+	wall, mono := s.clock.Now().Round(0), s.clock.Monotonic()
+	if !s.lastWall.IsZero() {
+		step := wall.Sub(s.lastWall) - (mono - s.lastMono)
+		if step >= clockStepThreshold || step <= -clockStepThreshold {
+			shifted := false
+			for _, j := range *jobs {
+				if isInterval(j.schelule) {
+					j.next = j.next.Add(step)
+					j.nextTime.set(j.next)
+					shifted = true
+				}
+			}
+			if shifted {
+				heap.Init(jobs)
+			}
+		}
+	}
+	s.lastWall, s.lastMono = wall, mono
+}
+
+// isInterval reports whether the schedule fires at the intervals
+// from the last fire, rather than at the times of the wall clock.
+func isInterval(schedule Schedule) bool {
+	switch schedule.(type) {
+	case *periodSchedule, *afterSchedule, *repeatSchedule:
+		return true
+	}
+	return false
+}
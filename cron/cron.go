@@ -30,31 +30,36 @@ const (
 	hoursMask   uint64 = 0xffffff0000000000
 	daysMask    uint64 = 0x7fffffff00000000
 	monthsMask  uint64 = 0x7ff8000000000000
-	weeksMask   uint64 = 0x7ffffffff0000000
+	weeksMask   uint64 = 0x7fffffffffe00000
 )
 
 // A Expression represents a specific cron time expression.
 type Expression struct {
-	expression         string    // raw expression string
-	seconds            uint64    // 0~59 bit
-	minutes            uint64    // 0~59 bit
-	hours              uint64    // 0~23 bit
-	daysOfMonth        uint64    // 1~31 bit
-	workdaysOfMonth    uint64    // 1~31 bit
-	lastDayOfMonth     bool      // L Flag
-	lastWorkdayOfMonth bool      // LW Flag
-	months             uint64    // 1~12 bit
-	daysOfWeek         uint64    // 1~35 bit(5 weeks)
-	ithWeekdaysOfWeek  uint64    // 1~35 bit(# sections)
-	lastWeekdaysOfWeek uint64    // 1~35 bit(L sections)
-	years              [3]uint64 // 0~128 bit
+	expression         string         // raw expression string
+	seconds            uint64         // 0~59 bit
+	minutes            uint64         // 0~59 bit
+	hours              uint64         // 0~23 bit
+	daysOfMonth        uint64         // 1~31 bit
+	workdaysOfMonth    uint64         // 1~31 bit
+	lastDayOfMonth     bool           // L Flag
+	lastWorkdayOfMonth bool           // LW Flag
+	months             uint64         // 1~12 bit
+	daysOfWeek         uint64         // 1~42 bit(6 weeks)
+	ithWeekdaysOfWeek  uint64         // 1~42 bit(# sections)
+	lastWeekdaysOfWeek uint64         // 1~42 bit(L sections)
+	years              [3]uint64      // 0~128 bit
+	withSeconds        bool           // the seconds field is specified
+	withYears          bool           // the years field is specified
+	comment            string         // the trailing comment
+	loc                *time.Location // the location pinned by the TZ= prefix, nil if not pinned
 }
 
 // Next returns the closest time instant immediately following `fromTime` which
 // matches the cron expression `expr`.
 //
 // The `time.Location` of the returned time instant is the same as that of
-// `fromTime`.
+// `fromTime`. The expression is evaluated in the location of `fromTime`,
+// or in its pinned location if any, see Location.
 //
 // The zero value of time.Time is returned if no matching time instant exists
 // or if a `fromTime` is itself a zero value.
@@ -64,6 +69,18 @@ func (expr *Expression) Next(fromTime time.Time) time.Time {
 		return fromTime
 	}
 
+	if expr.loc != nil && fromTime.Location() != expr.loc {
+		next := expr.next(fromTime.In(expr.loc))
+		if next.IsZero() {
+			return next
+		}
+		return next.In(fromTime.Location())
+	}
+	return expr.next(fromTime)
+}
+
+// next returns the next time instant in the location of fromTime.
+func (expr *Expression) next(fromTime time.Time) time.Time {
 	// Since expr.nextSecond()-expr.nextMonth() expects that the
 	// supplied time stamp is a perfect match to the underlying cron
 	// expression, and since this function is an entry point where `fromTime`
@@ -141,6 +158,470 @@ func (expr *Expression) Next(fromTime time.Time) time.Time {
 	return expr.nextSecond(fromTime, actualDaysOfMonth)
 }
 
+// Prev returns the closest time instant immediately preceding `fromTime` which
+// matches the cron expression `expr`, it mirrors Next.
+//
+// The `time.Location` of the returned time instant is the same as that of
+// `fromTime`. The expression is evaluated in the location of `fromTime`,
+// or in its pinned location if any, see Location.
+//
+// The zero value of time.Time is returned if no matching time instant exists
+// since 1970, or if a `fromTime` is itself a zero value.
+func (expr *Expression) Prev(fromTime time.Time) time.Time {
+	if fromTime.IsZero() {
+		return fromTime
+	}
+
+	if expr.loc != nil && fromTime.Location() != expr.loc {
+		prev := expr.prev(fromTime.In(expr.loc))
+		if prev.IsZero() {
+			return prev
+		}
+		return prev.In(fromTime.Location())
+	}
+	return expr.prev(fromTime)
+}
+
+// prev returns the previous time instant in the location of fromTime,
+// the fields are searched descending from the latest candidate.
+func (expr *Expression) prev(fromTime time.Time) time.Time {
+	// the latest whole second strictly before fromTime
+	t := fromTime.Truncate(time.Second)
+	if !t.Before(fromTime) {
+		t = t.Add(-time.Second)
+	}
+	if t.Year() > 2099 {
+		t = time.Date(2099, 12, 31, 23, 59, 59, 0, t.Location())
+	}
+
+	ty, tm, td := t.Date()
+	th, tmi, ts := t.Clock()
+	for year := ty; year >= 1970; year-- {
+		if expr.matchYear(year) != year {
+			continue
+		}
+		monthMax := 12
+		if year == ty {
+			monthMax = int(tm)
+		}
+		for month := prevValue(expr.months, monthMax); month >= 1; month = prevValue(expr.months, month-1) {
+			latest := year == ty && month == int(tm)
+			actualDaysOfMonth := expr.calculateActualDaysOfMonth(year, month, t.Location())
+			dayMax := 31
+			if latest {
+				dayMax = td
+			}
+			for day := prevValue(actualDaysOfMonth, dayMax); day >= 1; day = prevValue(actualDaysOfMonth, day-1) {
+				limit := [3]int{23, 59, 59}
+				if latest && day == td {
+					limit = [3]int{th, tmi, ts}
+				}
+				if prev := expr.prevInDay(year, month, day, limit, t); !prev.IsZero() {
+					return prev
+				}
+			}
+		}
+	}
+	return time.Time{}
+}
+
+// prevInDay returns the latest matching time instant of the day, whose clock
+// is not later than the limit {hour, minute, second}, and not later than t.
+func (expr *Expression) prevInDay(year, month, day int, limit [3]int, t time.Time) time.Time {
+	for hour := prevValue(expr.hours, limit[0]); hour >= 0; hour = prevValue(expr.hours, hour-1) {
+		minuteMax := 59
+		if hour == limit[0] {
+			minuteMax = limit[1]
+		}
+		for minute := prevValue(expr.minutes, minuteMax); minute >= 0; minute = prevValue(expr.minutes, minute-1) {
+			secondMax := 59
+			if hour == limit[0] && minute == limit[1] {
+				secondMax = limit[2]
+			}
+			for second := prevValue(expr.seconds, secondMax); second >= 0; second = prevValue(expr.seconds, second-1) {
+				prev := time.Date(year, time.Month(month), day, hour, minute, second, 0, t.Location())
+				// skip the clocks in the daylight saving time gap
+				if prev.After(t) || prev.Day() != day || prev.Hour() != hour || prev.Minute() != minute {
+					continue
+				}
+				return prev
+			}
+		}
+	}
+	return time.Time{}
+}
+
+// prevValue returns the greatest value not greater than v in the field,
+// the value i is the bit startBit>>i. -1 is returned if there is no such value.
+func prevValue(field uint64, v int) int {
+	if v < 0 {
+		return -1
+	}
+	masked := field &^ ((startBit >> uint(v)) - 1)
+	if masked == 0 {
+		return -1
+	}
+	return 63 - bits.TrailingZeros64(masked)
+}
+
+// NextUnix returns the closest time instant immediately following `fromUnix`
+// which matches the cron expression `expr`, as seconds since the Unix epoch.
+// The cron expression is evaluated in the location `loc`, UTC if nil.
+// The location pinned by the `TZ=` prefix wins over `loc`, which is then ignored.
+//
+// -1 is returned if no matching time instant exists.
+func (expr *Expression) NextUnix(fromUnix int64, loc *time.Location) int64 {
+	if loc == nil {
+		loc = time.UTC
+	}
+
+	next := expr.Next(time.Unix(fromUnix, 0).In(loc))
+	if next.IsZero() {
+		return -1
+	}
+	return next.Unix()
+}
+
+// NextWithin returns the closest time instant immediately following `fromTime`
+// which matches the cron expression `expr`, and is before `fromTime + horizon`.
+//
+// The search doesn't go beyond the year of the horizon end, so it is
+// bounded even though the matching time instants are sparse.
+//
+// The zero value of time.Time is returned if no matching time instant exists
+// within the horizon.
+func (expr *Expression) NextWithin(fromTime time.Time, horizon time.Duration) time.Time {
+	if horizon <= 0 {
+		return time.Time{}
+	}
+	end := fromTime.Add(horizon)
+
+	// clear the years after the horizon end
+	bounded := *expr
+	for i := end.Year() + 1 - 1970; i < len(bounded.years)*64; i++ {
+		if i >= 0 {
+			bounded.years[i>>6] &^= startBit >> (i & 0x3f)
+		}
+	}
+
+	next := bounded.Next(fromTime)
+	if next.IsZero() || !next.Before(end) {
+		return time.Time{}
+	}
+	return next
+}
+
+// MinGap returns the smallest gap between the consecutive fires of the
+// `samples` fires following `fromTime`, e.g. 1s for `* * * * * *` and
+// 1m for `0 * * * * *`.
+//
+// false is returned if samples is less than 2, or if no more matching
+// time instants exist before the samples are taken.
+func (expr *Expression) MinGap(fromTime time.Time, samples int) (time.Duration, bool) {
+	if samples < 2 {
+		return 0, false
+	}
+
+	prev := expr.Next(fromTime)
+	if prev.IsZero() {
+		return 0, false
+	}
+	minGap := time.Duration(math.MaxInt64)
+	for i := 1; i < samples; i++ {
+		next := expr.Next(prev)
+		if next.IsZero() {
+			return 0, false
+		}
+		if gap := next.Sub(prev); gap < minGap {
+			minGap = gap
+		}
+		prev = next
+	}
+	return minGap, true
+}
+
+// MaxCount caps the count of CountUntil.
+const MaxCount = 1 << 16
+
+// CountUntil returns the count of the time instants in [from, deadline)
+// which match the cron expression, e.g. the runs left before a deadline.
+// The count is capped at MaxCount, to avoid runaway counting on the dense
+// expressions like `* * * * * *`.
+func (expr *Expression) CountUntil(from, deadline time.Time) int {
+	count := 0
+	for next := expr.Next(from.Add(-time.Nanosecond)); count < MaxCount; next = expr.Next(next) {
+		if next.IsZero() || !next.Before(deadline) {
+			break
+		}
+		count++
+	}
+	return count
+}
+
+// Comment returns the trailing comment of the cron expression,
+// empty if there is no comment.
+func (expr *Expression) Comment() string {
+	return expr.comment
+}
+
+// MatchTime reports whether t matches the expression, after t is truncated
+// to the finest granularity of the expression, that is, the second, or the
+// minute for the specs without the seconds field. So 12:00:00.5 matches
+// `0 0 12 * * *`, and 12:00:30 matches `0 12 * * *` but not `0 0 12 * * *`.
+// t is evaluated in its location, or in the pinned location if any.
+func (expr *Expression) MatchTime(t time.Time) bool {
+	if expr.loc != nil {
+		t = t.In(expr.loc)
+	}
+	t = t.Truncate(time.Second)
+	if !expr.withSeconds {
+		t = t.Add(-time.Duration(t.Second()) * time.Second)
+	}
+	return expr.matchFields(t)
+}
+
+// Matches reports whether t is exactly a time of the expression, down to
+// the second, e.g. 12:00:00 matches `0 12 * * *` but 12:00:30 and 12:00:00.5
+// don't, see MatchTime for the truncated matching. The day of month and the
+// day of week are matched as Next does. t is evaluated in its location, or in
+// the pinned location if any.
+func (expr *Expression) Matches(t time.Time) bool {
+	if expr.loc != nil {
+		t = t.In(expr.loc)
+	}
+	return t.Nanosecond() == 0 && expr.matchFields(t)
+}
+
+// matchFields reports whether each field of t matches the expression,
+// the sub-second part of t is ignored.
+func (expr *Expression) matchFields(t time.Time) bool {
+	year := t.Year()
+	if expr.matchYear(year) != year {
+		return false
+	}
+	days := expr.calculateActualDaysOfMonth(year, int(t.Month()), t.Location())
+	return expr.months&(startBit>>uint(t.Month())) != 0 &&
+		days&(startBit>>uint(t.Day())) != 0 &&
+		expr.hours&(startBit>>uint(t.Hour())) != 0 &&
+		expr.minutes&(startBit>>uint(t.Minute())) != 0 &&
+		expr.seconds&(startBit>>uint(t.Second())) != 0
+}
+
+// Location returns the location pinned by the `TZ=` or `CRON_TZ=` prefix
+// of the spec, e.g. `TZ=America/New_York 0 0 9 * * *`, in which the expression
+// is evaluated. nil is returned if the expression is evaluated in the location
+// of the time given to Next.
+func (expr *Expression) Location() *time.Location {
+	return expr.loc
+}
+
+// String returns the original spec of the expression.
+func (expr *Expression) String() string {
+	return expr.expression
+}
+
+// Equal reports whether expr and other match the same time instants,
+// regardless of how they are written.
+func (expr *Expression) Equal(other *Expression) bool {
+	if expr == other {
+		return true
+	}
+	if expr == nil || other == nil {
+		return false
+	}
+	return expr.seconds == other.seconds &&
+		expr.minutes == other.minutes &&
+		expr.hours == other.hours &&
+		expr.daysOfMonth == other.daysOfMonth &&
+		expr.workdaysOfMonth == other.workdaysOfMonth &&
+		expr.lastDayOfMonth == other.lastDayOfMonth &&
+		expr.lastWorkdayOfMonth == other.lastWorkdayOfMonth &&
+		expr.months == other.months &&
+		expr.daysOfWeek == other.daysOfWeek &&
+		expr.ithWeekdaysOfWeek == other.ithWeekdaysOfWeek &&
+		expr.lastWeekdaysOfWeek == other.lastWeekdaysOfWeek &&
+		expr.years == other.years &&
+		sameLocation(expr.loc, other.loc)
+}
+
+// Contains reports whether expr matches every time instant matched by other,
+// e.g. `0 0 * * * *` contains `0 0 9 * * MON`. It compares the fields rather
+// than the instants, so false may be returned for some superset, e.g. if both
+// restrict the days in different ways.
+func (expr *Expression) Contains(other *Expression) bool {
+	if !sameLocation(expr.loc, other.loc) {
+		return false
+	}
+	for i := range expr.years {
+		if other.years[i]&^expr.years[i] != 0 {
+			return false
+		}
+	}
+	return other.seconds&^expr.seconds == 0 &&
+		other.minutes&^expr.minutes == 0 &&
+		other.hours&^expr.hours == 0 &&
+		other.months&^expr.months == 0 &&
+		(expr.everyDay() || expr.sameDays(other))
+}
+
+// Disjoint reports whether expr and other never match the same time instant,
+// because one of the seconds, minutes, hours, months and years fields has no
+// common value, e.g. `0 0 * * * *` and `0 30 * * * *`. The days are not
+// compared, so false may be returned for some disjoint expressions.
+func (expr *Expression) Disjoint(other *Expression) bool {
+	if !sameLocation(expr.loc, other.loc) {
+		return false
+	}
+	years := false
+	for i := range expr.years {
+		years = years || expr.years[i]&other.years[i] != 0
+	}
+	return !years ||
+		expr.seconds&other.seconds == 0 ||
+		expr.minutes&other.minutes == 0 ||
+		expr.hours&other.hours == 0 ||
+		expr.months&other.months == 0
+}
+
+// everyDay reports whether the days are not restricted.
+func (expr *Expression) everyDay() bool {
+	return expr.daysOfMonth == daysMask && expr.daysOfWeek == weeksMask &&
+		expr.workdaysOfMonth == 0 && !expr.lastDayOfMonth && !expr.lastWorkdayOfMonth &&
+		expr.ithWeekdaysOfWeek == 0 && expr.lastWeekdaysOfWeek == 0
+}
+
+// sameDays reports whether the day fields of expr and other are the same.
+func (expr *Expression) sameDays(other *Expression) bool {
+	return expr.daysOfMonth == other.daysOfMonth &&
+		expr.workdaysOfMonth == other.workdaysOfMonth &&
+		expr.lastDayOfMonth == other.lastDayOfMonth &&
+		expr.lastWorkdayOfMonth == other.lastWorkdayOfMonth &&
+		expr.daysOfWeek == other.daysOfWeek &&
+		expr.ithWeekdaysOfWeek == other.ithWeekdaysOfWeek &&
+		expr.lastWeekdaysOfWeek == other.lastWeekdaysOfWeek
+}
+
+// sameLocation reports whether the pinned locations are the same.
+func sameLocation(a, b *time.Location) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.String() == b.String()
+}
+
+// Combine returns the single expression matching the union of a and b,
+// when they differ only in one field, e.g. `0 0 * * MON` and `0 0 * * FRI`
+// are combined to `0 0 * * MON,FRI`. It is cheaper than the union of schedules.
+//
+// false is returned if the union can't be expressed by a single expression.
+func Combine(a, b *Expression) (*Expression, bool) {
+	domA := [...]uint64{a.daysOfMonth, a.workdaysOfMonth, boolBit(a.lastDayOfMonth), boolBit(a.lastWorkdayOfMonth)}
+	domB := [...]uint64{b.daysOfMonth, b.workdaysOfMonth, boolBit(b.lastDayOfMonth), boolBit(b.lastWorkdayOfMonth)}
+	dowA := [...]uint64{a.daysOfWeek, a.ithWeekdaysOfWeek, a.lastWeekdaysOfWeek}
+	dowB := [...]uint64{b.daysOfWeek, b.ithWeekdaysOfWeek, b.lastWeekdaysOfWeek}
+
+	diffs := 0
+	for _, same := range []bool{
+		a.seconds == b.seconds,
+		a.minutes == b.minutes,
+		a.hours == b.hours,
+		domA == domB,
+		a.months == b.months,
+		dowA == dowB,
+		a.years == b.years,
+	} {
+		if !same {
+			diffs++
+		}
+	}
+	if diffs > 1 || !sameLocation(a.loc, b.loc) {
+		return nil, false
+	}
+
+	// The days are the union of the restricted day fields, an unrestricted
+	// one is ignored unless both are unrestricted, so the day field is
+	// combined only if the other is unrestricted or both are restricted.
+	domRestricted := a.daysOfMonth != daysMask
+	dowRestricted := a.daysOfWeek != weeksMask
+	if domA != domB && dowRestricted &&
+		(a.daysOfMonth == daysMask || b.daysOfMonth == daysMask ||
+			a.daysOfMonth|b.daysOfMonth == daysMask) {
+		return nil, false
+	}
+	if dowA != dowB && domRestricted &&
+		(a.daysOfWeek == weeksMask || b.daysOfWeek == weeksMask ||
+			a.daysOfWeek|b.daysOfWeek == weeksMask) {
+		return nil, false
+	}
+
+	expr := &Expression{
+		seconds:            a.seconds | b.seconds,
+		minutes:            a.minutes | b.minutes,
+		hours:              a.hours | b.hours,
+		daysOfMonth:        a.daysOfMonth | b.daysOfMonth,
+		workdaysOfMonth:    a.workdaysOfMonth | b.workdaysOfMonth,
+		lastDayOfMonth:     a.lastDayOfMonth || b.lastDayOfMonth,
+		lastWorkdayOfMonth: a.lastWorkdayOfMonth || b.lastWorkdayOfMonth,
+		months:             a.months | b.months,
+		daysOfWeek:         a.daysOfWeek | b.daysOfWeek,
+		ithWeekdaysOfWeek:  a.ithWeekdaysOfWeek | b.ithWeekdaysOfWeek,
+		lastWeekdaysOfWeek: a.lastWeekdaysOfWeek | b.lastWeekdaysOfWeek,
+		withSeconds:        a.withSeconds || b.withSeconds,
+		withYears:          a.withYears || b.withYears,
+		loc:                a.loc,
+	}
+	for i := range expr.years {
+		expr.years[i] = a.years[i] | b.years[i]
+	}
+	expr.expression = expr.Normalized()
+	return expr, true
+}
+
+func boolBit(b bool) uint64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// IsPortable reports whether the expression uses only the standard syntax
+// of Vixie cron, that is, neither the seconds and years fields nor
+// the L, W and # flags nor the TZ= prefix were specified. The Normalized form
+// of a portable expression can be exported to the standard cron.
+func (expr *Expression) IsPortable() bool {
+	return !expr.withSeconds && !expr.withYears && expr.loc == nil &&
+		expr.workdaysOfMonth == 0 &&
+		!expr.lastDayOfMonth &&
+		!expr.lastWorkdayOfMonth &&
+		expr.ithWeekdaysOfWeek == 0 &&
+		expr.lastWeekdaysOfWeek == 0
+}
+
+// maxExcludedDates bounds the dates skipped by NextExcluding.
+const maxExcludedDates = 1 << 12
+
+// NextExcluding returns the closest time instant immediately following `fromTime`
+// which matches the cron expression `expr`, and whose date is not excluded.
+//
+// The `excluded` predicate is called with the matching time instants, once it
+// returns true, all the matching time instants of that date are skipped.
+//
+// The zero value of time.Time is returned if no matching time instant exists,
+// or if too many dates are excluded.
+func (expr *Expression) NextExcluding(fromTime time.Time, excluded func(time.Time) bool) time.Time {
+	next := expr.Next(fromTime)
+	for i := 0; i < maxExcludedDates && !next.IsZero(); i++ {
+		if !excluded(next) {
+			return next
+		}
+
+		// skip to the last second of the date
+		y, m, d := next.Date()
+		next = expr.Next(time.Date(y, m, d, 23, 59, 59, 0, next.Location()))
+	}
+	return time.Time{}
+}
+
 func (expr *Expression) matchYear(year int) int {
 	if year > 2099 {
 		return 0
@@ -376,14 +857,21 @@ func (expr *Expression) calculateActualDaysOfMonth(year, month int, loc *time.Lo
 		actualDaysOfMonth |= expr.daysOfWeek << int(firstWeekday)
 
 		// days of week of specific week in the month(4#2)
-		// expr.specificWeekDaysOfWeek << to set bit 1 is the frist day of month
-		actualDaysOfMonth |= expr.ithWeekdaysOfWeek << int(firstWeekday)
+		// the bit n+1 is the ith weekday, where n = (ith-1)*7 + weekday,
+		// counted from the first such weekday of the month rather than the calendar week
+		for ith := expr.ithWeekdaysOfWeek; ith != 0; {
+			n := bits.LeadingZeros64(ith) - 1
+			ith &^= startBit >> (n + 1)
+			week, weekday := n/7, n%7
+			day := 1 + (weekday-int(firstWeekday)+7)%7 + week*7
+			actualDaysOfMonth |= startBit >> day
+		}
 
 		// Last days of week of the month({Weekday}L)
 		// expr.lastWeekDaysOfWeek << to set bit 1 is the frist day of month
 		lastWeekdays := expr.lastWeekdaysOfWeek << int(firstWeekday)
-		// keep it for the last week
-		lastWeekdays = (lastWeekdays << (lastDay - 7)) >> (lastDay - 7)
+		// keep it for the last 7 days
+		lastWeekdays = (lastWeekdays << (lastDay - 6)) >> (lastDay - 6)
 		actualDaysOfMonth |= lastWeekdays
 	}
 
@@ -6,6 +6,8 @@ package cron
 
 import (
 	"fmt"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
@@ -198,6 +200,32 @@ var crontests = []crontest{
 			{"2012-07-14 23:59:59", "Sun 2012-07-15 00:00"},
 		},
 	},
+
+	// Named weekday/weekend
+	{
+		"@weekday",
+		"Mon 2006-01-02 15:04",
+		[]crontimes{
+			{"2013-01-04 00:00:00", "Mon 2013-01-07 00:00"},
+			{"2013-01-05 12:00:00", "Mon 2013-01-07 00:00"},
+			{"2013-01-07 00:00:00", "Tue 2013-01-08 00:00"},
+			{"2013-01-08 00:00:00", "Wed 2013-01-09 00:00"},
+			{"2013-01-09 00:00:00", "Thu 2013-01-10 00:00"},
+			{"2013-01-10 00:00:00", "Fri 2013-01-11 00:00"},
+			{"2013-03-29 00:00:00", "Mon 2013-04-01 00:00"},
+		},
+	},
+	{
+		"@weekend",
+		"Mon 2006-01-02 15:04",
+		[]crontimes{
+			{"2013-01-01 00:00:00", "Sat 2013-01-05 00:00"},
+			{"2013-01-05 00:00:00", "Sun 2013-01-06 00:00"},
+			{"2013-01-06 00:00:00", "Sat 2013-01-12 00:00"},
+			{"2013-03-25 00:00:00", "Sat 2013-03-30 00:00"},
+			{"2013-03-30 00:00:00", "Sun 2013-03-31 00:00"},
+		},
+	},
 	// TODO: more tests
 }
 
@@ -226,6 +254,109 @@ func TestZero(t *testing.T) {
 	assert.True(t, next.IsZero(), `("* * * * * 2014").Next(time.Time{})`)
 }
 
+func TestNextExcluding(t *testing.T) {
+	weekend := func(t time.Time) bool {
+		return t.Weekday() == time.Saturday || t.Weekday() == time.Sunday
+	}
+	layout := "Mon 2006-01-02 15:04"
+
+	expr := MustParse("0 9,18 * * *")
+	from := time.Date(2020, 4, 24, 10, 0, 0, 0, time.UTC) // Friday
+	want := []string{
+		"Fri 2020-04-24 18:00",
+		"Mon 2020-04-27 09:00",
+		"Mon 2020-04-27 18:00",
+		"Tue 2020-04-28 09:00",
+	}
+	for _, w := range want {
+		from = expr.NextExcluding(from, weekend)
+		assert.Equal(t, w, from.Format(layout))
+	}
+
+	all := func(time.Time) bool { return true }
+	assert.True(t, expr.NextExcluding(from, all).IsZero())
+	assert.True(t, MustParse("0 0 0 1 1 * 2020").NextExcluding(from, weekend).IsZero())
+}
+
+func TestNextUnix(t *testing.T) {
+	locs := []*time.Location{nil, time.UTC, time.FixedZone("UTC+8", 8*3600)}
+	from := time.Date(2013, 1, 1, 0, 0, 0, 0, time.UTC)
+	for _, test := range crontests {
+		expr := MustParse(test.expr)
+		for _, loc := range locs {
+			locFrom := from
+			if loc != nil {
+				locFrom = from.In(loc)
+			}
+			next := expr.Next(locFrom)
+			nextUnix := expr.NextUnix(from.Unix(), loc)
+			for i := 0; i < 5; i++ {
+				assert.Equal(t, next.Unix(), nextUnix, test.expr)
+				next = expr.Next(next)
+				nextUnix = expr.NextUnix(nextUnix, loc)
+			}
+		}
+	}
+
+	assert.EqualValues(t, -1, MustParse("0 0 0 1 1 * 2000").NextUnix(from.Unix(), nil))
+
+	// the pinned location wins
+	tokyo, err := time.LoadLocation("Asia/Tokyo")
+	assert.NoError(t, err)
+	pinned := MustParse("TZ=Asia/Tokyo 0 0 10 * * *")
+	want := time.Date(2013, 1, 1, 10, 0, 0, 0, tokyo).Unix()
+	for _, loc := range locs {
+		assert.Equal(t, want, pinned.NextUnix(from.Unix(), loc))
+	}
+}
+
+func TestEqual(t *testing.T) {
+	equals := [][2]string{
+		{"0 0 * * 6,7", "0 0 * * 0,6"},
+		{"@daily", "0 0 * * *"},
+		{"@weekday", "0 0 0 ? * MON-FRI"},
+		{"*/15 * * * *", "0 0,15,30,45 * * * *"},
+	}
+	for _, pair := range equals {
+		assert.True(t, MustParse(pair[0]).Equal(MustParse(pair[1])), pair)
+	}
+
+	assert.False(t, MustParse("0 0 * * 6").Equal(MustParse("0 0 * * 0")))
+	assert.False(t, MustParse("0 0 L * *").Equal(MustParse("0 0 LW * *")))
+	assert.False(t, MustParse("0 0 * * *").Equal(nil))
+}
+
+func TestContains(t *testing.T) {
+	contains := [][2]string{
+		{"0 0 * * * *", "0 0 9 * * MON"},
+		{"* * * * * *", "0 0 0 29 2 ?"},
+		{"0 0 * * MON-FRI", "0 0 * 1-6 MON-FRI"},
+		{"@daily", "0 0 0 * * * 2030"},
+	}
+	for _, pair := range contains {
+		assert.True(t, MustParse(pair[0]).Contains(MustParse(pair[1])), pair)
+	}
+
+	assert.False(t, MustParse("0 0 9 * * MON").Contains(MustParse("0 0 * * * *")))
+	assert.False(t, MustParse("0 0 * * MON").Contains(MustParse("0 0 1 * *")))
+	assert.False(t, MustParse("TZ=Asia/Tokyo 0 0 * * * *").Contains(MustParse("0 0 9 * * *")))
+}
+
+func TestDisjoint(t *testing.T) {
+	disjoint := [][2]string{
+		{"0 0 * * * *", "0 30 * * * *"},
+		{"0 0 9 * * *", "0 0 10 * * *"},
+		{"0 0 0 29 2 ?", "0 0 0 * 3 ?"},
+		{"0 0 0 * * * 2030", "0 0 0 * * * 2031"},
+	}
+	for _, pair := range disjoint {
+		assert.True(t, MustParse(pair[0]).Disjoint(MustParse(pair[1])), pair)
+	}
+
+	assert.False(t, MustParse("0 0 0 29 2 ?").Disjoint(MustParse("0 0 0 ? * MON")))
+	assert.False(t, MustParse("0 0 * * MON").Disjoint(MustParse("0 0 * * TUE"))) // days not compared
+}
+
 func TestInterval_Interval60Issue(t *testing.T) {
 	_, err := Parse("*/60 * * * * *")
 	if err == nil {
@@ -248,6 +379,156 @@ func TestInterval_Interval60Issue(t *testing.T) {
 	}
 }
 
+func TestInterval_StepGuidance(t *testing.T) {
+	tests := []struct {
+		spec string
+		msg  string
+	}{
+		{"*/60 * * * * *", "syntax error in second field: '*/60', the step must be less than 60, write '0' for only the first value"},
+		{"2/60 * * * * *", "syntax error in second field: '2/60', the step must be less than 60, write '2' for only the first value"},
+		{"2-20/61 * * * * *", "syntax error in second field: '2-20/61', the step must be less than 60, write '2' for only the first value"},
+		{"0 */24 * * *", "syntax error in hour field: '*/24', the step must be less than 24, write '0' for only the first value"},
+		{"0 0 * */12 *", "syntax error in month field: '*/12', the step must be less than 12, write '1' for only the first value"},
+		{"*/0 * * * * *", "syntax error in second field: '*/0'"},
+		{"60/60 * * * * *", "syntax error in second field: '60/60'"},
+	}
+
+	for _, test := range tests {
+		_, err := Parse(test.spec)
+		if assert.Error(t, err, test.spec) {
+			assert.Equal(t, test.msg, err.Error())
+		}
+	}
+}
+
+func TestParser_RequireSeconds(t *testing.T) {
+	p := Parser{RequireSeconds: true}
+	_, err := p.Parse("* * * * *")
+	if assert.Error(t, err) {
+		assert.Equal(t, "seconds field required", err.Error())
+	}
+
+	_, err = p.Parse("* * * * * *")
+	assert.NoError(t, err)
+	_, err = p.Parse("@daily")
+	assert.NoError(t, err)
+
+	_, err = Parse("* * * * *")
+	assert.NoError(t, err)
+}
+
+func TestParser_TwoDigitYears(t *testing.T) {
+	p := Parser{TwoDigitYears: true}
+	from := time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		spec string
+		next string
+	}{
+		{"0 0 0 1 1 * 24", "2024-01-01"},
+		{"0 0 0 1 1 * 70", "2070-01-01"},
+		{"0 0 0 1 1 * 5", "2005-01-01"},
+		{"0 0 0 1 1 * 24-26", "2024-01-01"},
+		{"0 0 0 1 1 * 2023,24", "2023-01-01"},
+		{"0 0 0 1 1 * 22/2", "2022-01-01"},
+		{"0 0 0 1 1 * 2030", "2030-01-01"},
+	}
+	for _, test := range tests {
+		expr, err := p.Parse(test.spec)
+		if assert.NoError(t, err, test.spec) {
+			assert.Equal(t, test.next, expr.Next(from).Format("2006-01-02"), test.spec)
+		}
+	}
+
+	expr, _ := p.Parse("0 0 0 1 1 * 22/2")
+	assert.Equal(t, "2024-01-01", expr.Next(expr.Next(from)).Format("2006-01-02"))
+
+	for _, spec := range []string{"0 0 0 1 1 * 100", "0 0 0 1 1 * 024", "0 0 0 1 1 * 1969"} {
+		_, err := p.Parse(spec)
+		assert.Error(t, err, spec)
+	}
+
+	_, err := Parse("0 0 0 1 1 * 24")
+	assert.Error(t, err)
+	_, err = Parse("0 0 0 1 1 * 70")
+	assert.Error(t, err)
+}
+
+func TestParseShared(t *testing.T) {
+	defer ClearParseCache()
+
+	const spec = "0 30 8 * * MON-FRI"
+	exprs := make([]*Expression, 16)
+	var wg sync.WaitGroup
+	for i := range exprs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			exprs[i], _ = ParseShared(spec)
+		}(i)
+	}
+	wg.Wait()
+
+	for _, expr := range exprs {
+		assert.NotNil(t, expr)
+		assert.True(t, exprs[0] == expr)
+	}
+
+	_, err := ParseShared("0 30 8 * * FOO")
+	assert.Error(t, err)
+
+	ClearParseCache()
+	expr, _ := ParseShared(spec)
+	assert.False(t, exprs[0] == expr)
+}
+
+func TestStepFromOffset(t *testing.T) {
+	tests := []struct {
+		field   string
+		seconds []int
+	}{
+		{"10/15", []int{10, 25, 40, 55}},
+		{"0/7", []int{0, 7, 14, 21, 28, 35, 42, 49, 56}},
+		{"3/20", []int{3, 23, 43}},
+		{"10-50/15", []int{10, 25, 40}},
+		{"59/1", []int{59}},
+		{"1/59", []int{1}},
+	}
+
+	for _, test := range tests {
+		expr := MustParse(test.field + " * * * * *")
+		assert.Equal(t, test.seconds, bitValues(expr.seconds, 0, 63), test.field)
+		expr = MustParse("0 " + test.field + " * * * *")
+		assert.Equal(t, test.seconds, bitValues(expr.minutes, 0, 63), test.field)
+	}
+
+	_, err := Parse("60/15 * * * * *")
+	assert.Error(t, err)
+}
+
+func TestMonthNamesWithStep(t *testing.T) {
+	tests := []struct {
+		field  string
+		months []int
+	}{
+		{"JAN-DEC/3", []int{1, 4, 7, 10}},
+		{"MAR-SEP/2", []int{3, 5, 7, 9}},
+		{"Feb-Nov/2", []int{2, 4, 6, 8, 10}},
+		{"oct/1", []int{10, 11, 12}},
+		{"FEB/5", []int{2, 7, 12}},
+		{"DEC", []int{12}},
+	}
+
+	for _, test := range tests {
+		expr := MustParse("0 0 1 " + test.field + " *")
+		assert.Equal(t, test.months, bitValues(expr.months, 0, 63), test.field)
+	}
+
+	for _, field := range []string{"JAN-DEC/MAR", "JAN-FOO/2", "JAN-DEC/12"} {
+		_, err := Parse("0 0 1 " + field + " *")
+		assert.Error(t, err, field)
+	}
+}
+
 var benchmarkExpressions = []string{
 	"0 * * * * *",
 	"@hourly",
@@ -282,3 +563,641 @@ func BenchmarkNext(b *testing.B) {
 		next = expr.Next(next)
 	}
 }
+
+func TestNormalized(t *testing.T) {
+	tests := []struct {
+		spec       string
+		normalized string
+	}{
+		{"* * * * *", "* * * * *"},
+		{"0 * * * * *", "0 * * * * *"},
+		{"* * * * * *", "* * * * * *"},
+		{"0 0 * * * * *", "0 0 * * * * *"},
+		{"0 0 0 * * * 2020", "0 0 0 * * * 2020"},
+		{"*/15 9-17 * * MON-FRI", "0,15,30,45 9-17 * * 1-5"},
+		{"0 30 3 15W 3/3 ? 2020-2022,2030", "0 30 3 15W 3,6,9,12 * 2020-2022,2030"},
+		{"0 0 L,LW * 5L,1#2", "0 0 L,LW * 1#2,5L"},
+		{"0 0 * * 6,7", "0 0 * * 0,6"},
+		{"0 0 1,2 JAN-MAR,JUL ?", "0 0 1,2 1-3,7 *"},
+		{"@weekday", "0 0 * * 1-5"},
+		{"@hourly", "0 * * * *"},
+	}
+
+	for _, test := range tests {
+		expr := MustParse(test.spec)
+		assert.Equal(t, test.normalized, expr.Normalized(), test.spec)
+		// normalized form is stable
+		assert.Equal(t, test.normalized, MustParse(test.normalized).Normalized(), test.spec)
+	}
+}
+
+func TestDayOfWeekWrapRange(t *testing.T) {
+	layout := "Mon 2006-01-02"
+	tests := []struct {
+		field string
+		from  string
+		nexts []string
+	}{
+		{"FRI-MON", "Thu 2020-04-23", []string{"Fri 2020-04-24", "Sat 2020-04-25", "Sun 2020-04-26", "Mon 2020-04-27", "Fri 2020-05-01"}},
+		{"FRI-MON", "Wed 2020-05-27", []string{"Fri 2020-05-29", "Sat 2020-05-30", "Sun 2020-05-31", "Mon 2020-06-01", "Fri 2020-06-05"}},
+		{"SAT-SUN", "Wed 2020-05-27", []string{"Sat 2020-05-30", "Sun 2020-05-31", "Sat 2020-06-06", "Sun 2020-06-07"}},
+		{"6-7", "Wed 2020-05-27", []string{"Sat 2020-05-30", "Sun 2020-05-31", "Sat 2020-06-06"}},
+		{"7-1", "Wed 2020-05-27", []string{"Sun 2020-05-31", "Mon 2020-06-01", "Sun 2020-06-07"}},
+		{"FRI-MON/2", "Wed 2020-05-27", []string{"Fri 2020-05-29", "Sun 2020-05-31", "Fri 2020-06-05"}},
+	}
+
+	for _, test := range tests {
+		expr := MustParse("0 0 12 * * " + test.field)
+		from, _ := time.Parse(layout, test.from)
+		for _, next := range test.nexts {
+			from = expr.Next(from)
+			assert.Equal(t, next, from.Format(layout), test.field)
+		}
+	}
+}
+
+func TestNextWithin(t *testing.T) {
+	from := time.Date(2021, 3, 1, 0, 0, 0, 0, time.UTC)
+	day := 24 * time.Hour
+	tests := []struct {
+		expr    string
+		horizon time.Duration
+		next    time.Time
+	}{
+		{"0 0 0 29 2 *", 365 * day, time.Time{}},
+		{"0 0 0 29 2 *", 3 * 365 * day, time.Time{}},
+		{"0 0 0 29 2 *", 4 * 365 * day, time.Date(2024, 2, 29, 0, 0, 0, 0, time.UTC)},
+		{"0 0 12 * * *", time.Hour, time.Time{}},
+		{"0 0 12 * * *", 12 * time.Hour, time.Time{}}, // the end is excluded
+		{"0 0 12 * * *", day, time.Date(2021, 3, 1, 12, 0, 0, 0, time.UTC)},
+		{"0 0 0 1 1 * 2030", 8 * 365 * day, time.Time{}},
+		{"0 0 0 1 1 * 2030", 9 * 366 * day, time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)},
+		{"0 0 12 * * *", 0, time.Time{}},
+	}
+
+	for _, test := range tests {
+		expr := MustParse(test.expr)
+		assert.Equal(t, test.next, expr.NextWithin(from, test.horizon), test.expr)
+	}
+
+	// expr is not changed
+	expr := MustParse("0 0 0 1 1 * 2030")
+	expr.NextWithin(from, day)
+	assert.Equal(t, time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC), expr.Next(from))
+}
+
+func TestDayOfWeekSundayRange(t *testing.T) {
+	fields := []string{"5-7", "FRI-SAT,SUN", "5,6,0", "5-6,7", "FRI-SUN", "5-6,0-0"}
+	want := MustParse("0 0 0 * * " + fields[0])
+
+	from := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	for _, field := range fields[1:] {
+		expr := MustParse("0 0 0 * * " + field)
+		assert.True(t, want.Equal(expr), field)
+
+		next1, next2 := from, from
+		for i := 0; i < 160; i++ { // more than a year
+			next1, next2 = want.Next(next1), expr.Next(next2)
+			assert.Equal(t, next1, next2, field)
+			wd := next2.Weekday()
+			assert.True(t, wd == time.Friday || wd == time.Saturday || wd == time.Sunday, field)
+		}
+	}
+}
+
+func TestComment(t *testing.T) {
+	tests := []struct {
+		spec    string
+		comment string
+		same    string
+	}{
+		{"0 0 * * * # nightly-backup", "nightly-backup", "0 0 * * *"},
+		{"0 0 * * *\t#  nightly backup ", "nightly backup", "0 0 * * *"},
+		{"0 0 * * 6#5", "", "0 0 * * 6#5"},
+		{"0 0 * * 6#5 # last Saturday", "last Saturday", "0 0 * * 6#5"},
+		{"@daily # midnight", "midnight", "@daily"},
+		{"0 0 * * * ## #", "# #", "0 0 * * *"},
+	}
+
+	for _, test := range tests {
+		expr, err := Parse(test.spec)
+		if !assert.NoError(t, err, test.spec) {
+			continue
+		}
+		assert.Equal(t, test.comment, expr.Comment(), test.spec)
+		assert.Equal(t, test.spec, expr.String())
+		assert.True(t, MustParse(test.same).Equal(expr), test.spec)
+	}
+
+	expr := MustParse("0 0 * * 6#5")
+	from := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	assert.Equal(t, time.Date(2020, 2, 29, 0, 0, 0, 0, time.UTC), expr.Next(from))
+
+	for _, spec := range []string{"# comment only", "  # ", "0 0 * * *#"} {
+		_, err := Parse(spec)
+		assert.Error(t, err, spec)
+	}
+}
+
+func TestDebugString(t *testing.T) {
+	// the day of month is in the fourth field of the 7-field spec
+	debug := MustParse("30 0 0 15W 3/3 ? 2020").DebugString()
+	for _, part := range []string{
+		"seconds: 30\n",
+		"minutes: 0\n",
+		"hours: 0\n",
+		"days of month: \n",
+		"workdays of month: 15\n",
+		"months: 3,6,9,12\n",
+		"days of week: 1,2,3,4,5,6,7,8,9,10,",
+		",40,41,42\n",
+		"years: 2020\n",
+		"flags: L=false LW=false seconds=true years=true",
+	} {
+		assert.Contains(t, debug, part)
+	}
+
+	debug = MustParse("0 0 L,LW * 5L,1#2").DebugString()
+	for _, part := range []string{
+		"days of week: \n",
+		"nth weekdays of week: 9\n",
+		"last weekdays of week: 6,13,20,27,34,41\n",
+		"flags: L=true LW=true seconds=false years=false",
+	} {
+		assert.Contains(t, debug, part)
+	}
+}
+
+func TestWorkdayMonthBoundary(t *testing.T) {
+	tests := []struct {
+		spec  string
+		month string // the month to search, yyyy-mm
+		want  string
+	}{
+		{"0 0 0 1W * *", "2020-02", "Mon 2020-02-03"},  // the 1st is Saturday
+		{"0 0 0 1W * *", "2020-03", "Mon 2020-03-02"},  // the 1st is Sunday
+		{"0 0 0 2W * *", "2020-05", "Fri 2020-05-01"},  // the 2nd is Saturday
+		{"0 0 0 31W * *", "2020-10", "Fri 2020-10-30"}, // the 31st is Saturday
+		{"0 0 0 31W * *", "2020-05", "Fri 2020-05-29"}, // the 31st is Sunday
+		{"0 0 0 LW * *", "2020-10", "Fri 2020-10-30"},
+		{"0 0 0 LW * *", "2020-05", "Fri 2020-05-29"},
+		{"0 0 0 30W * *", "2025-11", "Fri 2025-11-28"}, // the last day 30th is Sunday
+		{"0 0 0 30W * *", "2020-08", "Mon 2020-08-31"}, // the 30th is Sunday, not the last day
+		{"0 0 0 30W * *", "2020-05", "Fri 2020-05-29"}, // the 30th is Saturday
+		{"0 0 0 LW * *", "2020-02", "Fri 2020-02-28"},  // the 29th is Saturday
+		{"0 0 0 29W * *", "2020-02", "Fri 2020-02-28"},
+	}
+
+	for _, test := range tests {
+		month, _ := time.Parse("2006-01", test.month)
+		next := MustParse(test.spec).Next(month.Add(-time.Second))
+		assert.Equal(t, test.want, next.Format("Mon 2006-01-02"), test.spec+" in "+test.month)
+	}
+}
+
+func TestIsPortable(t *testing.T) {
+	tests := []struct {
+		spec     string
+		portable bool
+	}{
+		{"0 0 * * MON", true},
+		{"*/5 9-17 1,15 JAN-JUN *", true},
+		{"0 0 ? * FRI-MON", true},
+		{"@daily", true},
+		{"0 0 LW * *", false},
+		{"0 0 L * *", false},
+		{"0 0 15W * *", false},
+		{"0 0 * * 5L", false},
+		{"0 0 * * MON#1", false},
+		{"0 0 0 * * *", false},
+		{"0 0 0 * * * 2025", false},
+	}
+
+	for _, test := range tests {
+		assert.Equal(t, test.portable, MustParse(test.spec).IsPortable(), test.spec)
+	}
+}
+
+func TestCombine(t *testing.T) {
+	tests := []struct {
+		a, b     string
+		combined string // empty if not combinable
+	}{
+		{"0 0 * * MON", "0 0 * * FRI", "0 0 * * 1,5"},
+		{"0 0 * * MON", "0 0 * * MON", "0 0 * * 1"},
+		{"0 9 * * *", "0 17 * * *", "0 9,17 * * *"},
+		{"0 0 1 * *", "0 0 L * *", "0 0 1,L * *"},
+		{"0 0 * JAN *", "0 0 * JUL *", "0 0 * 1,7 *"},
+		{"0 0 * * MON-FRI", "0 0 * * SAT,SUN", "0 0 * * *"},
+		{"0 0 0 * * * 2025", "0 0 0 * * * 2026", "0 0 0 * * * 2025,2026"},
+		{"0 0 * * MON", "30 0 * * FRI", ""},
+		{"0 0 1 * *", "0 0 1 * MON", ""},      // the day of week isn't restricted in a
+		{"0 0 * * MON", "0 0 1 * MON", ""},    // the day of month isn't restricted in a
+		{"0 0 1 * 1-5", "0 0 1 * 0,6", ""},    // the day of week becomes unrestricted
+		{"0 0 1-15 * 1", "0 0 16-31 * 1", ""}, // the day of month becomes unrestricted
+	}
+
+	from := time.Date(2024, 12, 1, 0, 0, 0, 0, time.UTC)
+	for _, test := range tests {
+		a, b := MustParse(test.a), MustParse(test.b)
+		expr, ok := Combine(a, b)
+		if test.combined == "" {
+			assert.False(t, ok, test.a+" | "+test.b)
+			continue
+		}
+		if !assert.True(t, ok, test.a+" | "+test.b) {
+			continue
+		}
+		assert.Equal(t, test.combined, expr.String())
+
+		// matches the union of a and b
+		na, nb, nc := from, from, from
+		for i := 0; i < 50; i++ {
+			na, nb = a.Next(nc), b.Next(nc)
+			nc = expr.Next(nc)
+			if nb.Before(na) {
+				na = nb
+			}
+			if !assert.Equal(t, na, nc, test.a+" | "+test.b) {
+				break
+			}
+		}
+	}
+}
+
+func TestMinGap(t *testing.T) {
+	from := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		spec string
+		gap  time.Duration
+	}{
+		{"* * * * * *", time.Second},
+		{"0 * * * * *", time.Minute},
+		{"0 * * * *", time.Hour},
+		{"0 0,45 * * * *", 15 * time.Minute},
+		{"0 0 9 * * MON-FRI", 24 * time.Hour},
+	}
+
+	for _, test := range tests {
+		gap, ok := MustParse(test.spec).MinGap(from, 10)
+		assert.True(t, ok, test.spec)
+		assert.Equal(t, test.gap, gap, test.spec)
+	}
+
+	_, ok := MustParse("* * * * * *").MinGap(from, 1)
+	assert.False(t, ok)
+	_, ok = MustParse("0 0 0 1 1 * 2020-2021").MinGap(from, 3) // exhausted early
+	assert.False(t, ok)
+	gap, ok := MustParse("0 0 0 1 1 * 2020-2022").MinGap(from.Add(-time.Second), 3)
+	assert.True(t, ok)
+	assert.Equal(t, 365*24*time.Hour, gap)
+}
+
+func TestWeekOfMonth(t *testing.T) {
+	days := func(spec string, year int, month time.Month) (days []int) {
+		expr := MustParse(spec)
+		from := time.Date(year, month, 1, 0, 0, 0, 0, time.UTC).Add(-time.Second)
+		for next := expr.Next(from); next.Month() == month; next = expr.Next(next) {
+			days = append(days, next.Day())
+		}
+		return
+	}
+
+	assert.Equal(t, []int{1, 2, 3, 4, 5, 6, 7}, days("0 0 W1 * *", 2021, time.February))
+	assert.Equal(t, []int{8, 9, 10, 11, 12, 13, 14}, days("0 0 W2 * *", 2021, time.March))
+	assert.Equal(t, []int{29, 30, 31}, days("0 0 W5 * *", 2021, time.January))
+	assert.Equal(t, []int{29, 30}, days("0 0 W5 * *", 2021, time.April))
+	assert.Equal(t, []int{29}, days("0 0 W5 * *", 2020, time.February))
+	assert.Equal(t, []int(nil), days("0 0 W5 * *", 2021, time.February))
+	assert.Equal(t, []int{1, 2, 3, 4, 5, 6, 7, 15}, days("0 0 W1,15 * *", 2021, time.May))
+
+	assert.True(t, MustParse("0 0 W2 * *").Equal(MustParse("0 0 8-14 * *")))
+	assert.Equal(t, time.Date(2021, 3, 29, 0, 0, 0, 0, time.UTC),
+		MustParse("0 0 W5 * *").Next(time.Date(2021, 2, 1, 0, 0, 0, 0, time.UTC)))
+
+	for _, spec := range []string{"0 0 W0 * *", "0 0 W6 * *", "0 0 W * *", "0 0 W1W * *"} {
+		_, err := Parse(spec)
+		assert.Error(t, err, spec)
+	}
+	assert.True(t, MustParse("0 0 2W * *").workdaysOfMonth != 0) // the nearest weekday is kept
+}
+
+func TestNextExplain(t *testing.T) {
+	expr := MustParse("0 0 L * FRI")
+	tests := []struct {
+		from time.Time
+		next time.Time
+		days DaySource
+	}{
+		{time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC), time.Date(2021, 1, 8, 0, 0, 0, 0, time.UTC), DayOfWeek},
+		{time.Date(2021, 1, 29, 0, 0, 0, 0, time.UTC), time.Date(2021, 1, 31, 0, 0, 0, 0, time.UTC), LastDayOfMonth},
+		{time.Date(2021, 4, 29, 0, 0, 0, 0, time.UTC), time.Date(2021, 4, 30, 0, 0, 0, 0, time.UTC), LastDayOfMonth | DayOfWeek},
+	}
+	for _, test := range tests {
+		next, reason := expr.NextExplain(test.from)
+		assert.Equal(t, test.next, next)
+		assert.Equal(t, test.days, reason.Days, reason.String())
+		assert.Equal(t, test.next.Day(), reason.Day)
+		assert.Equal(t, test.next.Weekday(), reason.Weekday)
+	}
+
+	_, reason := MustParse("0 30 9 * * *").NextExplain(time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC))
+	assert.Equal(t, MatchReason{Minute: 30, Hour: 9, Day: 1, Month: time.January, Year: 2021,
+		Weekday: time.Friday, Days: DayAny}, reason)
+	assert.Equal(t, "second=0 minute=30 hour=9 day=1 (any day) month=January year=2021 weekday=Friday", reason.String())
+
+	_, reason = MustParse("0 0 1,15W * MON#1").NextExplain(time.Date(2021, 1, 31, 0, 0, 0, 0, time.UTC))
+	assert.Equal(t, DayOfMonth|IthWeekdayOfMonth, reason.Days) // Feb 1, 2021 is the first Monday
+	_, reason = MustParse("0 0 1,15W * MON#1").NextExplain(time.Date(2021, 2, 1, 0, 0, 0, 0, time.UTC))
+	assert.Equal(t, WorkdayOfMonth, reason.Days) // Feb 15, 2021
+
+	next, reason := MustParse("0 0 0 1 1 * 2020").NextExplain(time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC))
+	assert.True(t, next.IsZero())
+	assert.Equal(t, MatchReason{}, reason)
+
+	// explained in the pinned location
+	tokyo, err := time.LoadLocation("Asia/Tokyo")
+	assert.NoError(t, err)
+	next, reason = MustParse("TZ=Asia/Tokyo 0 0 L * *").NextExplain(time.Date(2021, 4, 1, 0, 0, 0, 0, time.UTC))
+	assert.Equal(t, time.Date(2021, 4, 30, 0, 0, 0, 0, tokyo).Unix(), next.Unix())
+	assert.Equal(t, time.UTC, next.Location())
+	assert.Equal(t, MatchReason{Day: 30, Month: time.April, Year: 2021,
+		Weekday: time.Friday, Days: LastDayOfMonth}, reason)
+}
+
+func TestEvenOdd(t *testing.T) {
+	equals := [][2]string{
+		{"0 ODD * * * *", "0 1-59/2 * * * *"},
+		{"0 0 EVEN * * *", "0 0 0-23/2 * * *"},
+		{"0 0 odd * * *", "0 0 1-23/2 * * *"},
+		{"EVEN * * * * *", "*/2 * * * * *"},
+		{"0 0 EVEN * *", "0 0 2-31/2 * *"},
+		{"0 0 ODD * *", "0 0 1-31/2 * *"},
+		{"0 0 ODD,2 * *", "0 0 1-31/2,2 * *"},
+	}
+	for _, pair := range equals {
+		assert.True(t, MustParse(pair[0]).Equal(MustParse(pair[1])), pair)
+	}
+
+	from := time.Date(2021, 1, 1, 10, 0, 30, 0, time.UTC)
+	assert.Equal(t, time.Date(2021, 1, 1, 10, 1, 0, 0, time.UTC), MustParse("0 ODD * * * *").Next(from))
+	assert.Equal(t, time.Date(2021, 1, 1, 12, 0, 0, 0, time.UTC), MustParse("0 0 EVEN * * *").Next(from))
+	assert.Equal(t, time.Date(2021, 2, 2, 0, 0, 0, 0, time.UTC), // Jan 31 is odd
+		MustParse("0 0 EVEN * *").Next(time.Date(2021, 1, 30, 0, 0, 0, 0, time.UTC)))
+
+	for _, spec := range []string{"0 0 * EVEN *", "0 0 * * ODD", "0 0 EVEN/2 * *", "0 0 EVEN-ODD * *", "X/2 * * * * *"} {
+		_, err := Parse(spec)
+		assert.Error(t, err, spec)
+	}
+}
+
+func TestCountUntil(t *testing.T) {
+	from := time.Date(2021, 1, 4, 0, 0, 0, 0, time.UTC)
+	week := from.AddDate(0, 0, 7)
+	assert.Equal(t, 7, MustParse("0 0 * * *").CountUntil(from, week))                  // from is included
+	assert.Equal(t, 6, MustParse("0 0 * * *").CountUntil(from.Add(time.Second), week)) // deadline is excluded
+	assert.Equal(t, 5, MustParse("0 9 * * MON-FRI").CountUntil(from, week))
+	assert.Equal(t, 0, MustParse("0 0 * * *").CountUntil(week, from))
+	assert.Equal(t, 1, MustParse("0 0 0 1 1 * 2021").CountUntil(from.AddDate(-1, 0, 0), week))
+
+	assert.Equal(t, 3600, MustParse("* * * * * *").CountUntil(from, from.Add(time.Hour)))
+	assert.Equal(t, MaxCount, MustParse("* * * * * *").CountUntil(from, week)) // capped
+}
+
+func TestParser_SplitFields(t *testing.T) {
+	p := Parser{SplitFields: func(spec string) []string {
+		return strings.FieldsFunc(spec, func(r rune) bool { return r == '|' || r == ';' })
+	}}
+
+	piped, err := p.Parse("0|0|*|*|*")
+	if assert.NoError(t, err) {
+		spaced := MustParse("0 0 * * *")
+		assert.Equal(t, spaced.Normalized(), piped.Normalized())
+		from := time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+		for i := 0; i < 3; i++ {
+			assert.Equal(t, spaced.Next(from), piped.Next(from))
+			from = spaced.Next(from)
+		}
+	}
+
+	_, err = p.Parse("0;30;9;*;*;MON-FRI")
+	assert.NoError(t, err)
+	_, err = p.Parse("0|0|*|*")
+	if assert.Error(t, err) {
+		assert.Equal(t, "missing field(s)", err.Error())
+	}
+}
+
+func TestLocation(t *testing.T) {
+	ny, err := time.LoadLocation("America/New_York")
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	expr, err := Parse("TZ=America/New_York 0 0 9 * * * # open")
+	if assert.NoError(t, err) {
+		assert.Equal(t, ny.String(), expr.Location().String())
+		assert.Equal(t, "open", expr.Comment())
+		assert.Equal(t, "TZ=America/New_York 0 0 9 * * *", expr.Normalized())
+		assert.False(t, expr.IsPortable())
+
+		from := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+		next := expr.Next(from)
+		assert.Equal(t, time.UTC, next.Location())
+		assert.Equal(t, "2020-01-01 14:00", next.Format("2006-01-02 15:04"))
+		assert.Equal(t, "2020-01-01 09:00", next.In(ny).Format("2006-01-02 15:04"))
+
+		// the normalized form is parsed to the same expression
+		assert.True(t, expr.Equal(MustParse(expr.Normalized())))
+		assert.False(t, expr.Equal(MustParse("0 0 9 * * *")))
+	}
+
+	expr, err = Parse("CRON_TZ=UTC @daily")
+	if assert.NoError(t, err) {
+		assert.Equal(t, time.UTC, expr.Location())
+		from := time.Date(2020, 1, 1, 12, 0, 0, 0, ny)
+		assert.Equal(t, "2020-01-01 19:00", expr.Next(from).Format("2006-01-02 15:04"))
+	}
+
+	assert.Nil(t, MustParse("0 0 9 * * *").Location())
+	_, err = Parse("TZ=Mars/Olympus 0 0 9 * * *")
+	if assert.Error(t, err) {
+		assert.Equal(t, "invalid time zone: 'Mars/Olympus'", err.Error())
+	}
+	_, err = Parse("TZ=UTC")
+	assert.Error(t, err)
+
+	_, ok := Combine(MustParse("TZ=UTC 0 0 * * MON"), MustParse("0 0 * * FRI"))
+	assert.False(t, ok)
+}
+
+func TestNthAndLastWeekday(t *testing.T) {
+	expr := MustParse("0 0 0 * * MON#1,FRIL")
+	from := time.Date(2019, 12, 31, 0, 0, 0, 0, time.UTC)
+
+	// the first Monday and the last Friday of each month, by brute force
+	var wants []time.Time
+	for day := from.AddDate(0, 0, 1); day.Year() == 2020; day = day.AddDate(0, 0, 1) {
+		firstMonday := day.Weekday() == time.Monday && day.Day() <= 7
+		lastFriday := day.Weekday() == time.Friday && day.AddDate(0, 0, 7).Month() != day.Month()
+		if firstMonday || lastFriday {
+			wants = append(wants, day)
+		}
+	}
+	assert.Equal(t, 24, len(wants))
+
+	next := from
+	for _, want := range wants {
+		next = expr.Next(next)
+		assert.Equal(t, want.Format("Mon 2006-01-02"), next.Format("Mon 2006-01-02"))
+	}
+
+	// the nth weekday before the first weekday of the month, 2020-01-01 is Wednesday
+	tests := []struct {
+		spec string
+		next string
+	}{
+		{"0 0 0 * * MON#1", "Mon 2020-01-06"},
+		{"0 0 0 * * SUN#1", "Sun 2020-01-05"},
+		{"0 0 0 * * TUE#2", "Tue 2020-01-14"},
+		{"0 0 0 * * WED#1", "Wed 2020-01-01"},
+		{"0 0 0 * * TUE#5", "Tue 2020-03-31"},
+		{"0 0 0 * * 1#1,5L", "Mon 2020-01-06"},
+	}
+	for _, test := range tests {
+		assert.Equal(t, test.next, MustParse(test.spec).Next(from).Format("Mon 2006-01-02"), test.spec)
+	}
+}
+
+func TestMatchTime(t *testing.T) {
+	noon := time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+	withSeconds := MustParse("0 0 12 * * *")
+	assert.True(t, withSeconds.MatchTime(noon))
+	assert.True(t, withSeconds.MatchTime(noon.Add(500*time.Millisecond)))
+	assert.False(t, withSeconds.MatchTime(noon.Add(30*time.Second)))
+	assert.False(t, withSeconds.MatchTime(noon.Add(-time.Nanosecond)))
+
+	// the minute granularity
+	withoutSeconds := MustParse("0 12 * * *")
+	assert.True(t, withoutSeconds.MatchTime(noon.Add(30*time.Second)))
+	assert.False(t, withoutSeconds.MatchTime(noon.Add(time.Minute)))
+
+	// the days of month or the days of week
+	expr := MustParse("0 0 0 L * MON#1")
+	assert.True(t, expr.MatchTime(time.Date(2020, 1, 31, 0, 0, 0, 0, time.UTC)))
+	assert.True(t, expr.MatchTime(time.Date(2020, 1, 6, 0, 0, 0, 0, time.UTC)))
+	assert.False(t, expr.MatchTime(time.Date(2020, 1, 13, 0, 0, 0, 0, time.UTC)))
+	assert.False(t, MustParse("0 0 0 1 1 * 2021").MatchTime(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)))
+
+	// the same as Next
+	from := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	for _, spec := range []string{"*/7 */13 * * * *", "0 30 9 * * MON-FRI", "0 0 12 LW * *", "0 0 6 15W * *"} {
+		expr := MustParse(spec)
+		next := from
+		for i := 0; i < 50; i++ {
+			next = expr.Next(next)
+			assert.True(t, expr.MatchTime(next), "%s %v", spec, next)
+			prev := next.Add(-time.Second)
+			assert.Equal(t, expr.Next(prev.Add(-time.Second)).Equal(prev), expr.MatchTime(prev), "%s %v", spec, prev)
+		}
+	}
+}
+
+func TestMatches(t *testing.T) {
+	noon := time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+	assert.True(t, MustParse("0 0 12 * * *").Matches(noon))
+	assert.False(t, MustParse("0 0 12 * * *").Matches(noon.Add(500*time.Millisecond)))
+	assert.True(t, MustParse("0 12 * * *").Matches(noon))
+	assert.False(t, MustParse("0 12 * * *").Matches(noon.Add(30*time.Second)))
+	assert.True(t, MustParse("@daily").Matches(noon.Truncate(24*time.Hour)))
+
+	// the days of month or the days of week
+	expr := MustParse("0 0 0 15 * FRI")
+	assert.True(t, expr.Matches(time.Date(2020, 1, 15, 0, 0, 0, 0, time.UTC))) // Wed
+	assert.True(t, expr.Matches(time.Date(2020, 1, 3, 0, 0, 0, 0, time.UTC)))  // Fri
+	assert.False(t, expr.Matches(time.Date(2020, 1, 16, 0, 0, 0, 0, time.UTC)))
+
+	// the pinned location
+	ny, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skip(err)
+	}
+	pinned := MustParse("TZ=America/New_York 0 0 9 * * *")
+	assert.True(t, pinned.Matches(time.Date(2020, 1, 1, 14, 0, 0, 0, time.UTC)))
+	assert.False(t, pinned.Matches(time.Date(2020, 1, 1, 9, 0, 0, 0, time.UTC)))
+
+	// the repeated hour of the DST end matches twice
+	expr = MustParse("0 30 1 * * *")
+	first := time.Date(2020, 11, 1, 5, 30, 0, 0, time.UTC).In(ny)
+	assert.True(t, expr.Matches(first))
+	assert.True(t, expr.Matches(first.Add(time.Hour)))
+
+	// the same as Next
+	from := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	for _, spec := range []string{"*/7 */13 * * * *", "0 30 9 * * MON-FRI", "0 0 12 LW * *", "15 10 * * 1#2"} {
+		expr := MustParse(spec)
+		next := from
+		for i := 0; i < 50; i++ {
+			next = expr.Next(next)
+			assert.True(t, expr.Matches(next), "%s %v", spec, next)
+			assert.False(t, expr.Matches(next.Add(time.Nanosecond)), "%s %v", spec, next)
+			prev := next.Add(-time.Second)
+			assert.Equal(t, expr.Next(prev.Add(-time.Second)).Equal(prev), expr.Matches(prev), "%s %v", spec, prev)
+		}
+	}
+}
+
+func TestPrev(t *testing.T) {
+	layout := "Mon 2006-01-02 15:04:05"
+	tests := []struct {
+		spec string
+		from string
+		prev string
+	}{
+		{"0 0 12 * * *", "Wed 2020-01-01 12:00:00", "Tue 2019-12-31 12:00:00"},
+		{"0 0 12 * * *", "Wed 2020-01-01 12:00:01", "Wed 2020-01-01 12:00:00"},
+		{"*/15 * * * * *", "Wed 2020-01-01 00:00:00", "Tue 2019-12-31 23:59:45"},
+		{"0 30 9 * * MON-FRI", "Mon 2020-01-06 09:00:00", "Fri 2020-01-03 09:30:00"},
+		{"0 0 0 29 2 *", "Sun 2020-03-01 00:00:00", "Sat 2020-02-29 00:00:00"},
+		{"0 0 0 29 2 *", "Fri 2020-02-28 00:00:00", "Mon 2016-02-29 00:00:00"},
+		{"0 0 0 L * *", "Sun 2020-03-15 00:00:00", "Sat 2020-02-29 00:00:00"},
+		{"0 0 0 LW * *", "Sun 2020-06-28 00:00:00", "Fri 2020-05-29 00:00:00"},
+		{"0 0 0 15W * *", "Sun 2020-02-16 00:00:00", "Fri 2020-02-14 00:00:00"},
+		{"0 0 0 * * 6#5", "Wed 2020-03-04 00:00:00", "Sat 2020-02-29 00:00:00"},
+		{"0 0 0 * * 6#5", "Sat 2020-02-29 00:00:00", "Sat 2019-11-30 00:00:00"},
+		{"0 0 0 * * FRIL", "Tue 2020-03-31 00:00:00", "Fri 2020-03-27 00:00:00"},
+		{"0 0 0 * * MON#1", "Wed 2020-01-08 00:00:00", "Mon 2020-01-06 00:00:00"},
+		{"0 0 0 1 1 * 2019", "Wed 2020-01-01 00:00:00", "Tue 2019-01-01 00:00:00"},
+	}
+	for _, test := range tests {
+		from, _ := time.Parse(layout, test.from)
+		assert.Equal(t, test.prev, MustParse(test.spec).Prev(from).Format(layout), test.spec)
+	}
+
+	// the clock in the daylight saving time gap doesn't exist
+	if ny, err := time.LoadLocation("America/New_York"); assert.NoError(t, err) {
+		prev := MustParse("0 30 2 * * *").Prev(time.Date(2020, 3, 9, 0, 0, 0, 0, ny))
+		assert.Equal(t, "2020-03-07 02:30 EST", prev.Format("2006-01-02 15:04 MST"))
+	}
+
+	// no prior match
+	assert.True(t, MustParse("0 0 0 1 1 * 2030").Prev(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)).IsZero())
+	assert.True(t, MustParse("* * * * * *").Prev(time.Date(1970, 1, 1, 0, 0, 0, 0, time.UTC)).IsZero())
+	assert.True(t, MustParse("* * * * * *").Prev(time.Time{}).IsZero())
+	assert.Equal(t, "2099-12-31 23:59:59",
+		MustParse("* * * * * *").Prev(time.Date(2150, 1, 1, 0, 0, 0, 0, time.UTC)).Format("2006-01-02 15:04:05"))
+
+	// the mirror of Next
+	from := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	for _, spec := range []string{"*/7 */13 * * * *", "0 30 9 * * MON-FRI", "0 0 12 LW * *",
+		"0 0 6 15W * *", "0 0 0 * * 6#5", "0 0 0 L * MON#1,FRIL"} {
+		expr := MustParse(spec)
+		next := expr.Next(from)
+		for i := 0; i < 50; i++ {
+			after := expr.Next(next)
+			assert.Equal(t, next, expr.Prev(after), "%s %v", spec, after)
+			assert.Equal(t, next, expr.Prev(after.Add(-500*time.Millisecond)), "%s %v", spec, after)
+			next = after
+		}
+	}
+}
@@ -0,0 +1,119 @@
+// Copyright (c) 2019,CAO HONGJU. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package cron
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// DaySource is a set of the parts of the expression which match a day.
+type DaySource int
+
+// The parts of the expression which match a day.
+const (
+	DayAny             DaySource = 1 << iota // both day fields are `*`
+	DayOfMonth                               // the days of month, e.g. `15` or `1-10`
+	LastDayOfMonth                           // `L` in the day-of-month field
+	LastWorkdayOfMonth                       // `LW` in the day-of-month field
+	WorkdayOfMonth                           // `{Number}W` in the day-of-month field
+	DayOfWeek                                // the days of week, e.g. `FRI` or `MON-FRI`
+	IthWeekdayOfMonth                        // `{Weekday}#{Number}` in the day-of-week field
+	LastWeekdayOfMonth                       // `{Weekday}L` in the day-of-week field
+)
+
+var daySourceNames = []string{
+	"any day",
+	"day of month",
+	"last day of month",
+	"last workday of month",
+	"nearest workday",
+	"day of week",
+	"n-th weekday of month",
+	"last weekday of month",
+}
+
+func (ds DaySource) String() string {
+	var names []string
+	for i, name := range daySourceNames {
+		if ds&(1<<uint(i)) != 0 {
+			names = append(names, name)
+		}
+	}
+	return strings.Join(names, ", ")
+}
+
+// MatchReason records the matched values of the fields, and the parts of
+// the expression which match the day.
+type MatchReason struct {
+	Second, Minute, Hour int
+	Day                  int
+	Month                time.Month
+	Year                 int
+	Weekday              time.Weekday
+	Days                 DaySource
+}
+
+func (mr MatchReason) String() string {
+	return fmt.Sprintf("second=%d minute=%d hour=%d day=%d (%s) month=%s year=%d weekday=%s",
+		mr.Second, mr.Minute, mr.Hour, mr.Day, mr.Days, mr.Month, mr.Year, mr.Weekday)
+}
+
+// NextExplain is like Next, but also returns the reason of the match,
+// e.g. whether the day matches `L` or `FRI` of `0 0 L * FRI`.
+//
+// The fields of the reason are in the location the expression is pinned to
+// by the `TZ=` prefix, if any, as the expression is evaluated there.
+//
+// The zero MatchReason is returned if no matching time instant exists.
+func (expr *Expression) NextExplain(fromTime time.Time) (time.Time, MatchReason) {
+	next := expr.Next(fromTime)
+	if next.IsZero() {
+		return next, MatchReason{}
+	}
+
+	local := next
+	if expr.loc != nil {
+		local = next.In(expr.loc)
+	}
+	return next, MatchReason{
+		Second:  local.Second(),
+		Minute:  local.Minute(),
+		Hour:    local.Hour(),
+		Day:     local.Day(),
+		Month:   local.Month(),
+		Year:    local.Year(),
+		Weekday: local.Weekday(),
+		Days:    expr.daySources(local),
+	}
+}
+
+// daySources returns the parts of the expression which match the day of t.
+// Each part is evaluated alone like the expression with only the part.
+func (expr *Expression) daySources(t time.Time) (sources DaySource) {
+	if expr.daysOfMonth == daysMask && expr.daysOfWeek == weeksMask {
+		return DayAny
+	}
+
+	bit := startBit >> uint(t.Day())
+	match := func(part Expression, source DaySource) {
+		if part.calculateActualDaysOfMonth(t.Year(), int(t.Month()), t.Location())&bit != 0 {
+			sources |= source
+		}
+	}
+	if expr.daysOfMonth != daysMask {
+		match(Expression{daysOfMonth: expr.daysOfMonth, daysOfWeek: weeksMask}, DayOfMonth)
+		match(Expression{lastDayOfMonth: expr.lastDayOfMonth, daysOfWeek: weeksMask}, LastDayOfMonth)
+		match(Expression{lastWorkdayOfMonth: expr.lastWorkdayOfMonth, daysOfWeek: weeksMask}, LastWorkdayOfMonth)
+		match(Expression{workdaysOfMonth: expr.workdaysOfMonth, daysOfWeek: weeksMask}, WorkdayOfMonth)
+	}
+	if expr.daysOfWeek != weeksMask {
+		match(Expression{daysOfMonth: daysMask, daysOfWeek: expr.daysOfWeek}, DayOfWeek)
+		match(Expression{daysOfMonth: daysMask, ithWeekdaysOfWeek: expr.ithWeekdaysOfWeek}, IthWeekdayOfMonth)
+		match(Expression{daysOfMonth: daysMask, lastWeekdaysOfWeek: expr.lastWeekdaysOfWeek}, LastWeekdayOfMonth)
+	}
+	return
+}
@@ -0,0 +1,180 @@
+// Copyright (c) 2019,CAO HONGJU. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package cron
+
+import (
+	"strconv"
+	"strings"
+)
+
+// Normalized returns the canonical form of the cron expression.
+//
+// The seconds field is present only if it was specified, so a 5-field
+// spec keeps the 5-field form, that is, `* * * * *` is normalized to
+// `* * * * *` and `0 * * * * *` stays `0 * * * * *`. Likewise, the years
+// field is present only if it was specified. The named expressions are
+// normalized to the 5-field form.
+func (expr *Expression) Normalized() string {
+	fields := make([]string, 0, 7)
+	if expr.withSeconds {
+		fields = append(fields, formatField(expr.seconds, secondsMask, 0, 59))
+	}
+	fields = append(fields,
+		formatField(expr.minutes, minutesMask, 0, 59),
+		formatField(expr.hours, hoursMask, 0, 23),
+		expr.formatDaysOfMonth(),
+		formatField(expr.months, monthsMask, 1, 12),
+		expr.formatDaysOfWeek())
+	if expr.withYears {
+		fields = append(fields, expr.formatYears())
+	}
+	if expr.loc != nil {
+		return tzPrefix + expr.loc.String() + " " + strings.Join(fields, " ")
+	}
+	return strings.Join(fields, " ")
+}
+
+// DebugString returns a raw dump of the bits and flags of the expression,
+// one field per line, for debugging and bug reports. Unlike Normalized,
+// the set bits are listed as they are, e.g. the days of week are
+// the bits 1-42 of the six weeks, Sunday of the first week is 1.
+func (expr *Expression) DebugString() string {
+	var years []int
+	for i := 0; i < len(expr.years); i++ {
+		for _, v := range bitValues(expr.years[i], 0, 63) {
+			years = append(years, i<<6+v+1970)
+		}
+	}
+
+	var b strings.Builder
+	field := func(name string, values []int) {
+		b.WriteString(name)
+		b.WriteString(": ")
+		for i, v := range values {
+			if i > 0 {
+				b.WriteByte(',')
+			}
+			b.WriteString(strconv.Itoa(v))
+		}
+		b.WriteByte('\n')
+	}
+	field("seconds", bitValues(expr.seconds, 0, 59))
+	field("minutes", bitValues(expr.minutes, 0, 59))
+	field("hours", bitValues(expr.hours, 0, 23))
+	field("days of month", bitValues(expr.daysOfMonth, 1, 31))
+	field("workdays of month", bitValues(expr.workdaysOfMonth, 1, 31))
+	field("months", bitValues(expr.months, 1, 12))
+	field("days of week", bitValues(expr.daysOfWeek, 1, 42))
+	field("nth weekdays of week", bitValues(expr.ithWeekdaysOfWeek, 1, 42))
+	field("last weekdays of week", bitValues(expr.lastWeekdaysOfWeek, 1, 42))
+	field("years", years)
+	b.WriteString("flags: L=" + strconv.FormatBool(expr.lastDayOfMonth) +
+		" LW=" + strconv.FormatBool(expr.lastWorkdayOfMonth) +
+		" seconds=" + strconv.FormatBool(expr.withSeconds) +
+		" years=" + strconv.FormatBool(expr.withYears))
+	return b.String()
+}
+
+func (expr *Expression) formatDaysOfMonth() string {
+	if expr.daysOfMonth == daysMask {
+		return "*"
+	}
+
+	var entries []string
+	if expr.daysOfMonth != 0 {
+		entries = append(entries, formatField(expr.daysOfMonth, daysMask, 1, 31))
+	}
+	for _, day := range bitValues(expr.workdaysOfMonth, 1, 31) {
+		entries = append(entries, strconv.Itoa(day)+"W")
+	}
+	if expr.lastDayOfMonth {
+		entries = append(entries, "L")
+	}
+	if expr.lastWorkdayOfMonth {
+		entries = append(entries, "LW")
+	}
+	return strings.Join(entries, ",")
+}
+
+func (expr *Expression) formatDaysOfWeek() string {
+	if expr.daysOfWeek == weeksMask {
+		return "*"
+	}
+
+	// the first week, sun is bit 1
+	var entries []string
+	if weekdays := bitValues(expr.daysOfWeek<<1, 0, 6); len(weekdays) > 0 {
+		entries = append(entries, formatValues(weekdays))
+	}
+	for _, n := range bitValues(expr.ithWeekdaysOfWeek<<1, 0, 34) {
+		entries = append(entries, strconv.Itoa(n%7)+"#"+strconv.Itoa(n/7+1))
+	}
+	for _, weekday := range bitValues(expr.lastWeekdaysOfWeek<<1, 0, 6) {
+		entries = append(entries, strconv.Itoa(weekday)+"L")
+	}
+	return strings.Join(entries, ",")
+}
+
+func (expr *Expression) formatYears() string {
+	var years []int
+	for i := 0; i < len(expr.years); i++ {
+		for _, v := range bitValues(expr.years[i], 0, 63) {
+			if year := i<<6 + v + 1970; year <= 2099 {
+				years = append(years, year)
+			}
+		}
+	}
+
+	if len(years) == 2099-1970+1 {
+		return "*"
+	}
+	return formatValues(years)
+}
+
+// formatField formats the bits of a field between min and max,
+// returns `*` if all bits of the mask are set.
+func formatField(v, mask uint64, min, max int) string {
+	if v&mask == mask {
+		return "*"
+	}
+	return formatValues(bitValues(v, min, max))
+}
+
+// bitValues returns the values of the set bits between min and max.
+func bitValues(v uint64, min, max int) []int {
+	var values []int
+	for i := min; i <= max; i++ {
+		if v&(startBit>>i) != 0 {
+			values = append(values, i)
+		}
+	}
+	return values
+}
+
+// formatValues formats the ascending values as a list,
+// three or more consecutive values are formatted as a range.
+func formatValues(values []int) string {
+	var b strings.Builder
+	for i := 0; i < len(values); {
+		j := i
+		for j+1 < len(values) && values[j+1] == values[j]+1 {
+			j++
+		}
+
+		if b.Len() > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(strconv.Itoa(values[i]))
+		if j-i >= 2 {
+			b.WriteByte('-')
+			b.WriteString(strconv.Itoa(values[j]))
+		} else if j > i {
+			b.WriteByte(',')
+			b.WriteString(strconv.Itoa(values[j]))
+		}
+		i = j + 1
+	}
+	return b.String()
+}
@@ -9,10 +9,13 @@ import (
 	"math"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
 )
 
 var (
-	allYears = [3]uint64{math.MaxUint64, math.MaxUint64, math.MaxUint64}
+	allYears   = [3]uint64{math.MaxUint64, math.MaxUint64, math.MaxUint64}
+	parseCache sync.Map // spec -> *Expression
 )
 
 // MustParse returns a new Expression pointer.
@@ -26,14 +29,122 @@ func MustParse(spec string) *Expression {
 	return expr
 }
 
+// ParseShared returns the shared Expression pointer of the spec.
+// The identical specs share the same Expression, which is safe
+// because an Expression is immutable once parsed.
+// An error is returned if a malformed cron expression is supplied.
+func ParseShared(spec string) (*Expression, error) {
+	if expr, ok := parseCache.Load(spec); ok {
+		return expr.(*Expression), nil
+	}
+
+	expr, err := Parse(spec)
+	if err != nil {
+		return nil, err
+	}
+	actual, _ := parseCache.LoadOrStore(spec, expr)
+	return actual.(*Expression), nil
+}
+
+// ClearParseCache clears the Expressions shared by ParseShared.
+func ClearParseCache() {
+	parseCache.Range(func(key, _ interface{}) bool {
+		parseCache.Delete(key)
+		return true
+	})
+}
+
+// A Parser parses cron expressions with custom options.
+// The zero value is the lenient parser used by Parse.
+type Parser struct {
+	// RequireSeconds rejects the specs without the seconds field,
+	// to avoid the ambiguity of the 5-field specs.
+	RequireSeconds bool
+	// TwoDigitYears interprets the one or two digit years 0-99
+	// in the year field as 2000-2099.
+	TwoDigitYears bool
+	// SplitFields splits the spec into fields, e.g. to parse "0|0|*|*|*",
+	// the empty fields are ignored. By default the fields are separated by spaces.
+	SplitFields func(spec string) []string
+}
+
 // Parse returns a new Expression pointer.
 // An error is returned if a malformed cron expression is supplied.
 func Parse(spec string) (*Expression, error) {
-	cron := strings.TrimSpace(spec)
+	var p Parser
+	return p.Parse(spec)
+}
+
+// Parse returns a new Expression pointer.
+// An error is returned if a malformed cron expression is supplied.
+//
+// The spec may be prefixed with the location in which it's evaluated, like
+// "TZ=America/New_York 0 0 9 * * *" or "CRON_TZ=UTC 0 0 * * *", see Expression.Location.
+//
+// A trailing comment starts with a '#' at the beginning of spec or after
+// a whitespace, e.g. "0 0 * * * # nightly-backup", it doesn't affect the
+// expression and is returned by Expression.Comment.
+func (p *Parser) Parse(spec string) (*Expression, error) {
+	cron, comment := splitComment(spec)
+	cron = strings.TrimSpace(cron)
+	loc, cron, err := splitLocation(cron)
+	if err != nil {
+		return nil, err
+	}
 	if len(cron) == 0 {
 		return nil, fmt.Errorf("empty spec string")
 	}
 
+	expr, err := p.parse(spec, cron)
+	if err != nil {
+		return nil, err
+	}
+	expr.expression = spec
+	expr.comment = comment
+	expr.loc = loc
+	return expr, nil
+}
+
+// tzPrefix is the prefix of the location written by Normalized,
+// CRON_TZ= is accepted as well.
+const tzPrefix = "TZ="
+
+// splitLocation splits the TZ= or CRON_TZ= prefix from cron.
+func splitLocation(cron string) (*time.Location, string, error) {
+	var name string
+	for _, prefix := range []string{tzPrefix, "CRON_" + tzPrefix} {
+		if strings.HasPrefix(cron, prefix) {
+			name = cron[len(prefix):]
+			break
+		}
+	}
+	if name == "" {
+		return nil, cron, nil
+	}
+
+	rest := ""
+	if i := strings.IndexAny(name, " \t"); i >= 0 {
+		name, rest = name[:i], strings.TrimSpace(name[i+1:])
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil || name == "" || name == "Local" {
+		return nil, "", fmt.Errorf("invalid time zone: '%s'", name)
+	}
+	return loc, rest, nil
+}
+
+// splitComment splits the trailing comment from spec. The '#' of
+// the nth weekday like "6#5" doesn't start a comment.
+func splitComment(spec string) (cron, comment string) {
+	for i := 0; i < len(spec); i++ {
+		if spec[i] == '#' && (i == 0 || spec[i-1] == ' ' || spec[i-1] == '\t') {
+			return spec[:i], strings.TrimSpace(spec[i+1:])
+		}
+	}
+	return spec, ""
+}
+
+func (p *Parser) parse(spec, cron string) (*Expression, error) {
 	// Handle named cron expression
 	if strings.HasPrefix(cron, "@") {
 		return parseNamedExpression(cron)
@@ -41,7 +152,12 @@ func Parse(spec string) (*Expression, error) {
 
 	// Handle normalize cron expression
 	expr := &Expression{expression: spec}
-	fields := strings.Split(cron, " ")
+	var fields []string
+	if p.SplitFields != nil {
+		fields = p.SplitFields(cron)
+	} else {
+		fields = strings.Split(cron, " ")
+	}
 	// remove empty fields
 	for i := len(fields) - 1; i >= 0; i-- {
 		if len(fields[i]) == 0 {
@@ -54,6 +170,9 @@ func Parse(spec string) (*Expression, error) {
 	if fieldCount < 5 {
 		return nil, fmt.Errorf("missing field(s)")
 	}
+	if fieldCount < 6 && p.RequireSeconds {
+		return nil, fmt.Errorf("seconds field required")
+	}
 
 	field := 0
 	parser := 0
@@ -62,9 +181,11 @@ func Parse(spec string) (*Expression, error) {
 		expr.seconds = startBit // 0 second
 		parser++                // set minute parser to the first
 	}
+	expr.withSeconds = fieldCount > 5
+	expr.withYears = fieldCount > 6
 
 	for field < fieldCount && parser < len(fieldParsers) {
-		if err := fieldParsers[parser].parse(expr, fields[field]); err != nil {
+		if err := p.fieldParser(parser).parse(expr, fields[field]); err != nil {
 			return nil, err
 		}
 		field++
@@ -85,11 +206,11 @@ func Parse(spec string) (*Expression, error) {
 		expr.lastWeekdaysOfWeek |= startBit
 	}
 
-	// expand to 5 week
+	// expand to 6 weeks, a month can span six calendar weeks
 	mask := uint64(0xfe00000000000000)
 	daysOfWeek := expr.daysOfWeek & mask
 	lastWeekdaysOfWeek := expr.lastWeekdaysOfWeek
-	for i := 0; i < 35; i += 7 {
+	for i := 0; i < 42; i += 7 {
 		expr.daysOfWeek |= daysOfWeek >> i
 		expr.lastWeekdaysOfWeek |= lastWeekdaysOfWeek >> i
 	}
@@ -101,6 +222,17 @@ func Parse(spec string) (*Expression, error) {
 	return expr, nil
 }
 
+// fieldParser returns the i-th field parser with the options of p.
+func (p *Parser) fieldParser(i int) *fieldParser {
+	fp := &fieldParsers[i]
+	if p.TwoDigitYears && fp.name == "year" {
+		yfp := *fp
+		yfp.atoi = atoyi
+		return &yfp
+	}
+	return fp
+}
+
 func parseNamedExpression(spec string) (*Expression, error) {
 	switch spec {
 	case "@yearly", "@annually":
@@ -136,6 +268,28 @@ func parseNamedExpression(spec string) (*Expression, error) {
 			daysOfWeek:  genWeekdayBits([7]bool{0: true}),
 			years:       allYears,
 		}, nil
+	case "@weekday":
+		return &Expression{
+			expression:  spec, // 0 0 0 * * 1-5 *
+			seconds:     startBit,
+			minutes:     startBit,
+			hours:       startBit,
+			daysOfMonth: daysMask,
+			months:      monthsMask,
+			daysOfWeek:  genWeekdayBits([7]bool{1: true, 2: true, 3: true, 4: true, 5: true}),
+			years:       allYears,
+		}, nil
+	case "@weekend":
+		return &Expression{
+			expression:  spec, // 0 0 0 * * 0,6 *
+			seconds:     startBit,
+			minutes:     startBit,
+			hours:       startBit,
+			daysOfMonth: daysMask,
+			months:      monthsMask,
+			daysOfWeek:  genWeekdayBits([7]bool{0: true, 6: true}),
+			years:       allYears,
+		}, nil
 	case "@daily", "@midnight":
 		return &Expression{
 			expression:  spec, // 0 0 0 * * * *
@@ -174,6 +328,8 @@ var fieldParsers = []fieldParser{
 		0, 59,
 		atoi,
 		nil,
+		0,
+		true,
 	},
 	{
 		"minute",
@@ -185,6 +341,8 @@ var fieldParsers = []fieldParser{
 		0, 59,
 		atoi,
 		nil,
+		0,
+		true,
 	},
 	{
 		"hour",
@@ -196,6 +354,8 @@ var fieldParsers = []fieldParser{
 		0, 23,
 		atoi,
 		nil,
+		0,
+		true,
 	},
 	{
 		"day of month",
@@ -207,6 +367,8 @@ var fieldParsers = []fieldParser{
 		1, 31,
 		atoi,
 		parseSpecDomEntry,
+		0,
+		true,
 	},
 	{
 		"month",
@@ -218,6 +380,8 @@ var fieldParsers = []fieldParser{
 		1, 12,
 		atomi,
 		nil,
+		0,
+		false,
 	},
 	{
 		"day of week",
@@ -229,6 +393,8 @@ var fieldParsers = []fieldParser{
 		0, 7,
 		atowi,
 		parseSpecDowEntry,
+		7,
+		false,
 	},
 	{
 		"year",
@@ -240,17 +406,27 @@ var fieldParsers = []fieldParser{
 		1970, 2099,
 		atoi,
 		nil,
+		0,
+		false,
 	},
 }
 
 const errPattern = "syntax error in %s field: '%s'"
 
+// errFractionalStepPattern reports the fractional step which can't be represented.
+const errFractionalStepPattern = "syntax error in %s field: '%s', the fractional step is not supported"
+
+// errStepPattern guides to write the single value for a step as large as the field.
+const errStepPattern = "syntax error in %s field: '%s', the step must be less than %d, write '%d' for only the first value"
+
 type fieldParser struct {
 	name            string
 	populateTo      func(expr *Expression, begin, end, step int)
 	min, max        int
 	atoi            func(string) (int, bool)
 	specEntryParser func(expr *Expression, entry string, atoi func(string) (int, bool)) bool
+	cycle           int  // the cycle of the values which a range can wrap through, 0 if can't
+	parity          bool // EVEN and ODD are allowed
 }
 
 func (fp *fieldParser) parse(expr *Expression, field string) error {
@@ -286,6 +462,9 @@ func (fp *fieldParser) parseStep(expr *Expression, entry string, step int) bool
 
 	// standard begin-end
 	idx := strings.IndexByte(entry, '-')
+	if idx == -1 {
+		return false
+	}
 	begin, ok := fp.atoi(entry[:idx])
 	if !ok || !fp.isValid(begin) {
 		return false
@@ -294,6 +473,12 @@ func (fp *fieldParser) parseStep(expr *Expression, entry string, step int) bool
 	if !ok || !fp.isValid(end) {
 		return false
 	}
+	if begin > end && fp.cycle > 0 { // wrap through the cycle, like FRI-MON
+		for i := begin; i <= end+fp.cycle; i += step {
+			fp.populateTo(expr, i%fp.cycle, i%fp.cycle, 1)
+		}
+		return true
+	}
 	fp.populateTo(expr, begin, end, step)
 	return true
 }
@@ -303,6 +488,14 @@ func (fp *fieldParser) parseEntry(expr *Expression, entry string) error {
 		fp.populateTo(expr, fp.min, fp.max, 1)
 		return nil
 	}
+	if parity := strings.ToUpper(entry); fp.parity && (parity == "EVEN" || parity == "ODD") {
+		first := fp.min
+		if (first%2 == 0) != (parity == "EVEN") {
+			first++
+		}
+		fp.populateTo(expr, first, fp.max, 2)
+		return nil
+	}
 	n, ok := fp.atoi(entry)
 	if ok { // one value
 		if !fp.isValid(n) {
@@ -315,7 +508,15 @@ func (fp *fieldParser) parseEntry(expr *Expression, entry string) error {
 	// step  /
 	idx := strings.IndexByte(entry, '/')
 	if idx != -1 {
-		step, ok := fp.atoi(entry[idx+1:])
+		if strings.IndexByte(entry[idx+1:], '.') != -1 {
+			return fmt.Errorf(errFractionalStepPattern, fp.name, entry)
+		}
+		step, ok := atoi(entry[idx+1:])
+		if ok && step > (fp.max-fp.min) {
+			if first, ok := fp.firstOfStep(entry[:idx]); ok {
+				return fmt.Errorf(errStepPattern, fp.name, entry, fp.max-fp.min+1, first)
+			}
+		}
 		if !ok || step < 1 || step > (fp.max-fp.min) {
 			return fmt.Errorf(errPattern, fp.name, entry)
 		}
@@ -339,6 +540,22 @@ func (fp *fieldParser) parseEntry(expr *Expression, entry string) error {
 	return nil
 }
 
+// firstOfStep returns the first value of the stepped entry like `*`, `2` or `2-20`.
+func (fp *fieldParser) firstOfStep(entry string) (int, bool) {
+	if entry == "*" {
+		return fp.min, true
+	}
+	if idx := strings.IndexByte(entry, '-'); idx != -1 {
+		end, ok := fp.atoi(entry[idx+1:])
+		if !ok || !fp.isValid(end) {
+			return 0, false
+		}
+		entry = entry[:idx]
+	}
+	n, ok := fp.atoi(entry)
+	return n, ok && fp.isValid(n)
+}
+
 func (fp *fieldParser) isValid(n int) bool {
 	return n >= fp.min && n <= fp.max
 }
@@ -357,6 +574,16 @@ func parseSpecDomEntry(expr *Expression, entry string, atoi func(string) (int, b
 		expr.lastDayOfMonth = true
 		return true
 	}
+	if strings.HasPrefix(entry, "W") { // the days of the n-th week, W2 is 8-14
+		n, ok := atoi(entry[1:])
+		if !ok || n < 1 || n > 5 {
+			return false
+		}
+		for day := (n-1)*7 + 1; day <= n*7 && day <= max; day++ {
+			expr.daysOfMonth |= startBit >> day
+		}
+		return true
+	}
 	if strings.HasSuffix(entry, "W") {
 		n, ok := atoi(entry[:len(entry)-1])
 		if !ok || n < min || n > max {
@@ -406,7 +633,7 @@ func parseSpecDowEntry(expr *Expression, entry string, atoi func(string) (int, b
 func genWeekdayBits(weekdays [7]bool) uint64 {
 	var v uint64
 	i := 1 // day start from 1
-	for k := 0; k < 5; k++ {
+	for k := 0; k < 6; k++ {
 		for _, b := range weekdays {
 			if b {
 				v |= startBit >> i
@@ -422,6 +649,15 @@ func atoi(s string) (int, bool) {
 	return i, err == nil
 }
 
+// atoyi converts the one or two digit year to 2000-2099.
+func atoyi(s string) (int, bool) {
+	n, ok := atoi(s)
+	if ok && len(s) <= 2 {
+		n += 2000
+	}
+	return n, ok
+}
+
 func atowi(s string) (int, bool) {
 	switch strings.ToLower(s) {
 	case `0`, `sun`, `sunday`:
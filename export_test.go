@@ -0,0 +1,18 @@
+// Copyright (c) 2019,CAO HONGJU. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package scheduler
+
+import "time"
+
+// queueNextTimes returns the next times of the jobs in the queue of s,
+// in the order of the heap, without dispatching the jobs.
+func queueNextTimes(s *Scheduler) (times []time.Time) {
+	s.exec(func(jobs *jobQueue) {
+		for _, j := range *jobs {
+			times = append(times, j.next)
+		}
+	})
+	return
+}
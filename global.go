@@ -8,46 +8,83 @@ import (
 	"fmt"
 	"os"
 	"os/signal"
+	"sync/atomic"
 	"syscall"
 	"time"
 )
 
 var (
-	defaultSchd = New() // location = time.Local
+	defaultSchd atomic.Value // *Scheduler
 )
 
 func init() {
+	s := New() // location = time.Local
+	defaultSchd.Store(s)
+
 	// cleaning when system signal is received
 	c := make(chan os.Signal, 1)
 	signal.Notify(c, syscall.SIGINT, syscall.SIGTERM)
-	go handleSignal(c)
+	go handleSignal(c, s, os.Exit)
 }
 
-func handleSignal(c <-chan os.Signal) {
+// handleSignal shutdowns the Scheduler s when the signal is received,
+// and then exits. s is the original default Scheduler, not the one
+// replaced by SetDefaultScheduler.
+func handleSignal(c <-chan os.Signal, s *Scheduler, exit func(code int)) {
 	for sig := range c {
 		switch sig {
 		case syscall.SIGTERM:
 			fallthrough
 		case syscall.SIGINT:
 			fmt.Fprintf(os.Stderr, "default scheduler received signal `%s`, exiting...\n", sig.String())
-			defaultSchd.ShutdownAndWait()
-			os.Exit(0)
+			s.ShutdownAndWait()
+			exit(0)
 		}
 	}
 }
 
+// DefaultScheduler returns the default Scheduler.
+func DefaultScheduler() *Scheduler {
+	return defaultSchd.Load().(*Scheduler)
+}
+
+// SetDefaultScheduler replaces the default Scheduler, e.g. with a Scheduler for tests.
+// The replaced Scheduler is not shutdown. The signal handler installed by
+// the package keeps shutting down the original default Scheduler on SIGINT
+// and SIGTERM, the Scheduler s is not shutdown by the signals.
+func SetDefaultScheduler(s *Scheduler) {
+	if s == nil {
+		return
+	}
+	defaultSchd.Store(s)
+}
+
 // AfterFunc posts the function f to the default Scheduler.
 // The function f will execute after specified delay only once,
 // and then remove from the Scheduler.
 func AfterFunc(delay time.Duration, f func(), tag interface{}) (*ManagedJob, error) {
-	return defaultSchd.AfterFunc(delay, f, tag)
+	return DefaultScheduler().AfterFunc(delay, f, tag)
 }
 
 // After posts the job to the default Scheduler.
 // The job will execute after specified delay only once,
 // and then remove from the Scheduler.
 func After(delay time.Duration, job Job, tag interface{}) (*ManagedJob, error) {
-	return defaultSchd.After(delay, job, tag)
+	return DefaultScheduler().After(delay, job, tag)
+}
+
+// AtFunc posts the function f to the default Scheduler.
+// The function f will execute at the specified time only once,
+// and then remove from the Scheduler.
+func AtFunc(t time.Time, f func(), tag interface{}) (*ManagedJob, error) {
+	return DefaultScheduler().AtFunc(t, f, tag)
+}
+
+// At posts the job to the default Scheduler.
+// The job will execute at the specified time only once,
+// and then remove from the Scheduler.
+func At(t time.Time, job Job, tag interface{}) (*ManagedJob, error) {
+	return DefaultScheduler().At(t, job, tag)
 }
 
 // PeriodFunc posts the function f to the default Scheduler.
@@ -55,7 +92,7 @@ func After(delay time.Duration, job Job, tag interface{}) (*ManagedJob, error) {
 // followed by a fixed period. If the execution time of f exceeds
 // the period, there will be multiple instances of f running at the same time.
 func PeriodFunc(initialDelay, period time.Duration, f func(), tag interface{}) (*ManagedJob, error) {
-	return defaultSchd.PeriodFunc(initialDelay, period, f, tag)
+	return DefaultScheduler().PeriodFunc(initialDelay, period, f, tag)
 }
 
 // Period posts the job to the default Scheduler.
@@ -63,45 +100,73 @@ func PeriodFunc(initialDelay, period time.Duration, f func(), tag interface{}) (
 // followed by a fixed period. If the execution time of job exceeds
 // the period, there will be multiple instances of job running at the same time.
 func Period(initialDelay, period time.Duration, job Job, tag interface{}) (*ManagedJob, error) {
-	return defaultSchd.Period(initialDelay, period, job, tag)
+	return DefaultScheduler().Period(initialDelay, period, job, tag)
+}
+
+// RepeatFunc posts the function f to the default Scheduler.
+// The function f will execute the first time at the specified delay,
+// followed by a fixed gap, for the given times.
+func RepeatFunc(initialDelay, gap time.Duration, times int, f func(), tag interface{}) (*ManagedJob, error) {
+	return DefaultScheduler().RepeatFunc(initialDelay, gap, times, f, tag)
+}
+
+// Repeat posts the job to the default Scheduler.
+// The job will execute the first time at the specified delay,
+// followed by a fixed gap, for the given times,
+// and then remove from the Scheduler.
+func Repeat(initialDelay, gap time.Duration, times int, job Job, tag interface{}) (*ManagedJob, error) {
+	return DefaultScheduler().Repeat(initialDelay, gap, times, job, tag)
 }
 
 // CronFunc posts the function f to the default Scheduler, and associate the given cron expression with it.
+// In addition to the cron expression, `@every <duration>` is accepted, e.g. `@every 30s` or `@every 1d`,
+// optionally aligned to the clock, e.g. `@every 15m@:00` fires at :00, :15, :30 and :45.
+// A fractional step is accepted in the seconds field, e.g. `*/0.5 * * * * *` fires every 500ms.
 func CronFunc(cronExpr string, f func(), tag interface{}) (*ManagedJob, error) {
-	return defaultSchd.CronFunc(cronExpr, f, tag)
+	return DefaultScheduler().CronFunc(cronExpr, f, tag)
 }
 
 // Cron posts the job to the default Scheduler, and associate the given cron expression with it.
+// In addition to the cron expression, `@every <duration>` is accepted, e.g. `@every 30s` or `@every 1d`,
+// optionally aligned to the clock, e.g. `@every 15m@:00` fires at :00, :15, :30 and :45.
+// A fractional step is accepted in the seconds field, e.g. `*/0.5 * * * * *` fires every 500ms.
 func Cron(cronExpr string, job Job, tag interface{}) (*ManagedJob, error) {
-	return defaultSchd.Cron(cronExpr, job, tag)
+	return DefaultScheduler().Cron(cronExpr, job, tag)
+}
+
+// ScheduleUntilSuccess posts the job to the default Scheduler, and associate the given schedule with it.
+// The job runs at the times of the schedule while it returns an error or panics,
+// and it's cancelled once it returns nil.
+func ScheduleUntilSuccess(schedule Schedule, job FallibleJob, tag interface{}) (*ManagedJob, error) {
+	return DefaultScheduler().ScheduleUntilSuccess(schedule, job, tag)
 }
 
 // PostFunc posts the function f to the default Scheduler, and associate the given schedule with it.
-func PostFunc(schedule Schedule, f func(), tag interface{}) (*ManagedJob, error) {
-	return defaultSchd.PostFunc(schedule, f, tag)
+func PostFunc(schedule Schedule, f func(), tag interface{}, options ...JobOption) (*ManagedJob, error) {
+	return DefaultScheduler().PostFunc(schedule, f, tag, options...)
 }
 
 // Post posts the job to the default Scheduler, and associate the given schedule with it.
-func Post(schedule Schedule, job Job, tag interface{}) (mjob *ManagedJob, err error) {
-	return defaultSchd.Post(schedule, job, tag)
+func Post(schedule Schedule, job Job, tag interface{}, options ...JobOption) (mjob *ManagedJob, err error) {
+	return DefaultScheduler().Post(schedule, job, tag, options...)
 }
 
 // Jobs returns the scheduled jobs of the global scheduler.
 func Jobs() (jobs []*ManagedJob) {
-	return defaultSchd.Jobs()
+	return DefaultScheduler().Jobs()
 }
 
 // Count returns jobs count of the global scheduler.
 func Count() int {
-	return defaultSchd.Count()
+	return DefaultScheduler().Count()
 }
 
 // Location returns the time zone location of the global scheduler.
 func Location() *time.Location {
-	return defaultSchd.Location()
+	return DefaultScheduler().Location()
 }
 
 // SetPanicHandler set the panic handler of the global scheduler.
 func SetPanicHandler(panicHandler PanicHandler) {
-	defaultSchd.SetPanicHandler(panicHandler)
+	DefaultScheduler().SetPanicHandler(panicHandler)
 }
@@ -5,6 +5,8 @@
 package scheduler
 
 import (
+	"os"
+	"syscall"
 	"testing"
 	"time"
 
@@ -23,6 +25,41 @@ func TestAfterFunc(t *testing.T) {
 	})
 }
 
+func TestAtFunc(t *testing.T) {
+	old := DefaultScheduler()
+	s := New()
+	SetDefaultScheduler(s)
+	defer func() {
+		SetDefaultScheduler(old)
+		s.Shutdown()
+	}()
+
+	fired := make(chan time.Time, 2)
+	at := time.Now().Add(50 * time.Millisecond)
+	mjob, err := AtFunc(at, func() {
+		fired <- time.Now()
+	}, "at")
+	assert.NoError(t, err)
+	assert.Equal(t, at.Unix(), mjob.NextTime().Unix())
+
+	got := <-fired
+	assert.False(t, got.Before(at))
+	<-time.After(50 * time.Millisecond)
+	assert.Equal(t, 0, len(fired), "only once")
+	assert.Equal(t, 0, Count())
+
+	// the past time fires as soon as possible
+	_, err = AtFunc(time.Now().Add(-time.Hour), func() {
+		fired <- time.Now()
+	}, "past")
+	assert.NoError(t, err)
+	select {
+	case <-fired:
+	case <-time.After(time.Second):
+		t.Error("the past time is not fired")
+	}
+}
+
 func TestPeriodFunc(t *testing.T) {
 	assert.NotPanics(t, func() {
 		out := make(chan bool, 1)
@@ -49,3 +86,54 @@ func TestCronFunc(t *testing.T) {
 		}
 	})
 }
+
+func TestSetDefaultScheduler(t *testing.T) {
+	old := DefaultScheduler()
+	s := New()
+	SetDefaultScheduler(s)
+	defer func() {
+		SetDefaultScheduler(old)
+		s.Shutdown()
+	}()
+	SetDefaultScheduler(nil) // ignored
+	assert.True(t, s == DefaultScheduler())
+
+	out := make(chan bool, 1)
+	mjob, err := CronFunc("@every 10ms", func() {
+		select {
+		case out <- true:
+		default:
+		}
+	}, "substituted")
+	assert.NoError(t, err)
+	defer mjob.Cancel()
+
+	assert.True(t, <-out)
+	jobs := s.Jobs()
+	assert.Equal(t, 1, len(jobs))
+	assert.Equal(t, "substituted", jobs[0].Tag())
+	for _, job := range old.Jobs() {
+		assert.NotEqual(t, "substituted", job.Tag())
+	}
+}
+
+func TestHandleSignal(t *testing.T) {
+	old := DefaultScheduler()
+	original := New()
+	s := New()
+	SetDefaultScheduler(s)
+	defer func() {
+		SetDefaultScheduler(old)
+		s.Shutdown()
+	}()
+
+	c := make(chan os.Signal, 1)
+	c <- syscall.SIGTERM
+	close(c)
+	code := -1
+	handleSignal(c, original, func(c int) { code = c })
+
+	assert.Equal(t, 0, code)
+	assert.True(t, original.Terminated())
+	assert.False(t, s.Terminated(), "the substituted scheduler is not shutdown")
+}
@@ -0,0 +1,144 @@
+// Copyright (c) 2019,CAO HONGJU. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package scheduler
+
+import "time"
+
+// A Group is a handle of the jobs posted via it, e.g. the jobs of a feature,
+// which can be cancelled or paused together.
+type Group struct {
+	s    *Scheduler
+	name string
+}
+
+// Group returns the handle of the group with the name. The handles with the
+// same name refer to the same group. The jobs not posted via a Group are
+// in the group with the empty name.
+func (s *Scheduler) Group(name string) *Group {
+	return &Group{s: s, name: name}
+}
+
+// Name returns the name of the group.
+func (g *Group) Name() string {
+	return g.name
+}
+
+// Group returns the name of the group the job is posted via.
+func (mjob *ManagedJob) Group() string {
+	return mjob.group
+}
+
+func (g *Group) option() JobOption {
+	return jobOptionFunc(func(j *ManagedJob) {
+		j.group = g.name
+	})
+}
+
+// AfterFunc posts the function f to the group like Scheduler.AfterFunc.
+func (g *Group) AfterFunc(delay time.Duration, f func(), tag interface{}) (*ManagedJob, error) {
+	return g.After(delay, JobFunc(f), tag)
+}
+
+// After posts the job to the group like Scheduler.After.
+func (g *Group) After(delay time.Duration, job Job, tag interface{}) (*ManagedJob, error) {
+	return g.Post(&afterSchedule{delay: delay}, job, tag)
+}
+
+// PeriodFunc posts the function f to the group like Scheduler.PeriodFunc.
+func (g *Group) PeriodFunc(initialDelay, period time.Duration, f func(), tag interface{}) (*ManagedJob, error) {
+	return g.Period(initialDelay, period, JobFunc(f), tag)
+}
+
+// Period posts the job to the group like Scheduler.Period.
+func (g *Group) Period(initialDelay, period time.Duration, job Job, tag interface{}) (*ManagedJob, error) {
+	schedule, err := newPeriodSchedule(initialDelay, period)
+	if err != nil {
+		return nil, err
+	}
+	return g.Post(schedule, job, tag)
+}
+
+// CronFunc posts the function f to the group like Scheduler.CronFunc.
+func (g *Group) CronFunc(cronExpr string, f func(), tag interface{}) (*ManagedJob, error) {
+	return g.Cron(cronExpr, JobFunc(f), tag)
+}
+
+// Cron posts the job to the group like Scheduler.Cron.
+func (g *Group) Cron(cronExpr string, job Job, tag interface{}) (*ManagedJob, error) {
+	schedule, err := parseCron(cronExpr)
+	if err != nil {
+		return nil, err
+	}
+	return g.Post(schedule, job, tag)
+}
+
+// PostFunc posts the function f to the group like Scheduler.PostFunc.
+func (g *Group) PostFunc(schedule Schedule, f func(), tag interface{}, options ...JobOption) (*ManagedJob, error) {
+	return g.Post(schedule, JobFunc(f), tag, options...)
+}
+
+// Post posts the job to the group like Scheduler.Post.
+func (g *Group) Post(schedule Schedule, job Job, tag interface{}, options ...JobOption) (*ManagedJob, error) {
+	return g.s.Post(schedule, job, tag, append(options, g.option())...)
+}
+
+// Jobs returns the scheduled jobs of the group.
+func (g *Group) Jobs() (jobs []*ManagedJob) {
+	g.s.exec(func(queue *jobQueue) {
+		for _, j := range *queue {
+			if j.group == g.name {
+				jobs = append(jobs, j)
+			}
+		}
+	})
+	return
+}
+
+// CancelAll cancels all the jobs of the group in a single operation
+// of the run loop, and returns the count of the cancelled jobs.
+func (g *Group) CancelAll() (count int) {
+	g.s.exec(func(queue *jobQueue) {
+		var members []*ManagedJob
+		for _, j := range *queue {
+			if j.group == g.name {
+				members = append(members, j)
+			}
+		}
+		for _, j := range members { // the removal reorders the queue
+			if g.s.removeJob(j, queue) {
+				count++
+			}
+		}
+	})
+	return
+}
+
+// PauseAll pauses all the jobs of the group in a single operation
+// of the run loop, see ManagedJob.Pause.
+func (g *Group) PauseAll() {
+	g.s.exec(func(queue *jobQueue) {
+		for _, j := range *queue {
+			if j.group == g.name {
+				j.Pause()
+			}
+		}
+	})
+}
+
+// ResumeAll resumes all the jobs of the group in a single operation
+// of the run loop, see ManagedJob.Resume.
+func (g *Group) ResumeAll() {
+	g.s.exec(func(queue *jobQueue) {
+		var members []*ManagedJob
+		for _, j := range *queue {
+			if j.group == g.name {
+				members = append(members, j)
+			}
+		}
+		for _, j := range members { // the resume reorders the queue
+			g.s.resumeJob(j, queue)
+		}
+	})
+}
@@ -0,0 +1,58 @@
+// Copyright (c) 2019,CAO HONGJU. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package scheduler
+
+import (
+	"testing"
+	"time"
+
+	"github.com/cnotch/scheduler/cron"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGroup(t *testing.T) {
+	s := NewManual(WithLocation(time.UTC))
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	s.Advance(start)
+
+	runs := map[string]int{}
+	job := func(name string) func() {
+		return func() { runs[name]++ }
+	}
+	billing, reports := s.Group("billing"), s.Group("reports")
+	billing.CronFunc("0 0 * * * *", job("invoice"), nil)
+	billing.PeriodFunc(time.Hour, time.Hour, job("refund"), nil)
+	billing.PostFunc(cron.MustParse("0 30 * * * *"), job("audit"), nil)
+	reports.CronFunc("0 0 * * * *", job("daily"), nil)
+	reports.AfterFunc(90*time.Minute, job("once"), nil)
+	s.PeriodFunc(time.Hour, time.Hour, job("ungrouped"), nil)
+
+	assert.Equal(t, 3, len(billing.Jobs()))
+	assert.Equal(t, 3, len(s.Group("billing").Jobs())) // the same group
+	assert.Equal(t, 1, len(s.Group("").Jobs()))
+	assert.Equal(t, "reports", reports.Jobs()[0].Group())
+
+	s.Advance(start.Add(time.Hour))
+	assert.Equal(t, map[string]int{"invoice": 1, "refund": 1, "audit": 1, "daily": 1, "ungrouped": 1}, runs)
+
+	billing.PauseAll()
+	s.Advance(start.Add(2 * time.Hour))
+	assert.Equal(t, map[string]int{"invoice": 1, "refund": 1, "audit": 1, "daily": 2, "once": 1, "ungrouped": 2}, runs)
+
+	billing.ResumeAll()
+	assert.Equal(t, 3, billing.CancelAll())
+	assert.Equal(t, 0, billing.CancelAll())
+	assert.Equal(t, 0, len(billing.Jobs()))
+	assert.Equal(t, 1, len(reports.Jobs()))
+	assert.Equal(t, 2, s.Count())
+
+	s.Advance(start.Add(3 * time.Hour))
+	assert.Equal(t, map[string]int{"invoice": 1, "refund": 1, "audit": 1, "daily": 3, "once": 1, "ungrouped": 3}, runs)
+
+	_, err := billing.PeriodFunc(0, time.Microsecond, job("bad"), nil)
+	assert.Error(t, err)
+	_, err = billing.CronFunc("bad", job("bad"), nil)
+	assert.Error(t, err)
+}
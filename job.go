@@ -5,6 +5,9 @@
 package scheduler
 
 import (
+	"context"
+	"errors"
+	"sync"
 	"sync/atomic"
 	"time"
 	"unsafe"
@@ -24,33 +27,221 @@ func (jf JobFunc) Run() {
 	jf()
 }
 
+// FallibleJob represent a 'job' that may fail, see Scheduler.ScheduleUntilSuccess.
+type FallibleJob interface {
+	// Run called by the Scheduler When the Schedule associated with the Job is triggered,
+	// it returns nil if the job succeeds.
+	Run() error
+}
+
+// FallibleJobFunc is an adapter to allow the use of ordinary functions as the FallibleJob interface.
+type FallibleJobFunc func() error
+
+// Run called by the Scheduler When the Schedule associated with the Job is triggered.
+func (jf FallibleJobFunc) Run() error {
+	return jf()
+}
+
+// untilSuccess is the job posted by ScheduleUntilSuccess.
+type untilSuccess struct {
+	job       FallibleJob
+	mjob      *ManagedJob
+	succeeded int32 // atomic, skip the runs after the success
+}
+
+func (us *untilSuccess) Run() {
+	if atomic.LoadInt32(&us.succeeded) != 0 {
+		return
+	}
+	if us.job.Run() != nil {
+		return // retry at the next time
+	}
+	if !atomic.CompareAndSwapInt32(&us.succeeded, 0, 1) {
+		return
+	}
+	if us.mjob.owner.inline {
+		go us.mjob.Cancel() // the run loop is running the job
+		return
+	}
+	us.mjob.Cancel()
+}
+
+// TimedJob is implemented by a Job that is told the time it's scheduled to run at.
+// The Scheduler calls RunAt instead of Run for the Job implementing TimedJob.
+type TimedJob interface {
+	// RunAt called with the scheduled time of the run, or the time given
+	// to ManagedJob.RunAt.
+	RunAt(t time.Time)
+}
+
+// ContextJob is implemented by a Job that takes a context.
+// The Scheduler calls RunContext instead of Run for the Job implementing ContextJob,
+// with the context of the Scheduler carrying the values of the job.
+type ContextJob interface {
+	// RunContext called with the context of the run.
+	RunContext(ctx context.Context)
+}
+
 // ManagedJob represent the job managed by the scheduler.
 type ManagedJob struct {
 	// heap fields
 	index int // index of the job in the heap
 	// immutable fields of the job
-	tag      interface{} // job tag, application provide
-	schelule Schedule
-	job      Job
-	remove   chan *ManagedJob
-	postTime time.Time
+	tag       interface{} // job tag, application provide
+	job       Job
+	remove    chan *ManagedJob
+	owner     *Scheduler // the scheduler the job is posted to
+	postTime  time.Time
+	values    map[interface{}]interface{} // the values attached by WithJobValue
+	labels    map[string]string           // the labels attached by WithLabels
+	priority  int                         // the dispatch priority among the jobs due at the same time
+	group     string                      // the name of the group the job is posted via
+	seq       uint64                      // the registration sequence
+	phased    bool                        // preserve the phase on resume, see ResumePreservePhase
+	exclusive bool                        // skip the fires overlapping a run, see Exclusive
 
 	// runtime fields
 	next     time.Time // next trigger time
 	prevTime lockedTime
 	nextTime lockedTime
+	disabled int32 // atomic, the job does not run when non-zero
+	latency  int64 // atomic, the latency of the last fire
+	hasRun   int32 // atomic, non-zero once the job has started a run
+	skipped  int64 // atomic, the fires skipped for overlapping
+
+	computeTime int64 // atomic, the time spent computing the next times
+
+	// the schedule and the completion notification, guarded by mu
+	mu         sync.Mutex
+	schelule   Schedule      // written by the run loop with mu held, see RescheduleWhere
+	running    int           // the runs in progress
+	runs       int           // the runs completed
+	removed    bool          // removed from the scheduler
+	done       chan struct{} // closed when a run completes
+	dead       chan struct{} // closed when removed and no runs in progress
+	deadClosed bool          // dead is closed, the manual runs may follow the removal
 	// TODO: more...
 }
 
 // Cancel cancel the scheduled job.
+// It's a no-op if the scheduler is terminated.
 func (mjob *ManagedJob) Cancel() {
+	if mjob.owner.manual != nil {
+		mjob.owner.removeOK(mjob)
+		return
+	}
+	select {
+	case mjob.remove <- mjob:
+	case <-mjob.owner.ctx.Done():
+	}
+}
+
+// CancelOK cancels the scheduled job like Cancel, and reports whether
+// the job was actually removed. It returns false if the scheduler is
+// terminated, or the job is no longer in the scheduler.
+func (mjob *ManagedJob) CancelOK() bool {
+	return mjob.owner.removeOK(mjob)
+}
+
+// RunSync runs the job immediately on the caller's goroutine, e.g. to validate
+// the job and warm up before its scheduled runs. The run is counted by RunCount,
+// and a panic is recovered and passed to the panic handler of the scheduler,
+// but the next time of the job is not changed. It's the caller's responsibility
+// to handle the blocking of the job.
+func (mjob *ManagedJob) RunSync() {
+	mjob.runStarted()
 	defer func() {
+		defer mjob.runCompleted(true)
 		if r := recover(); r != nil {
-			// when mjob.remove closed
+			panicHandler := mjob.owner.panicHandler.Load().(PanicHandler)
+			panicHandler(mjob, r)
 		}
 	}()
+	atomic.StoreInt32(&mjob.hasRun, 1)
+	mjob.run(time.Now())
+}
 
-	mjob.remove <- mjob
+// RunAt runs the job as if it were the time t on the caller's goroutine,
+// e.g. to replay or backfill the missed runs. The time is passed to a TimedJob,
+// and ignored by the other jobs. The run is like a scheduled run, except that
+// the next time of the job is not changed. The job cancelled or completed
+// can run too.
+func (mjob *ManagedJob) RunAt(t time.Time) {
+	s := mjob.owner
+	s.wg.Add(1)
+	mjob.runStarted()
+	s.safeRun(mjob, t)
+}
+
+// RunCount returns the count of the completed runs of the job.
+func (mjob *ManagedJob) RunCount() int {
+	mjob.mu.Lock()
+	defer mjob.mu.Unlock()
+	return mjob.runs
+}
+
+// HasRun reports whether the job has ever run, by the scheduler, RunAt or RunSync.
+// Unlike the zero PrevTime, it's not ambiguous with the run at the zero time.
+// The runs skipped by the locker or the per-tag concurrency don't count.
+func (mjob *ManagedJob) HasRun() bool {
+	return atomic.LoadInt32(&mjob.hasRun) != 0
+}
+
+// SkippedCount returns the count of the fires of the Exclusive job skipped
+// because its prior run was still active.
+func (mjob *ManagedJob) SkippedCount() int64 {
+	return atomic.LoadInt64(&mjob.skipped)
+}
+
+// SetEnabled enables or disables the job. A disabled job stays in the
+// scheduler and keeps advancing its next time, but does not run until
+// it is enabled again. Unlike canceling, the setting is persistent and
+// the job can be re-enabled at any time, for example on a config reload.
+func (mjob *ManagedJob) SetEnabled(enabled bool) {
+	var disabled int32
+	if !enabled {
+		disabled = 1
+	}
+	atomic.StoreInt32(&mjob.disabled, disabled)
+}
+
+// Enabled reports whether the job is enabled. Jobs are enabled by default.
+func (mjob *ManagedJob) Enabled() bool {
+	return atomic.LoadInt32(&mjob.disabled) == 0
+}
+
+// Pause disables the job like SetEnabled(false). The paused job keeps
+// advancing its next time on its schedule without running.
+func (mjob *ManagedJob) Pause() {
+	mjob.SetEnabled(false)
+}
+
+// Resume enables the paused job. A cron job stays on its original phase,
+// e.g. a daily 09:00 job resumed at 14:00 next fires at 09:00 tomorrow.
+// A Period or Repeat job that has fired restarts its interval from the resume
+// time, unless it's posted with ResumePreservePhase, which keeps the fires
+// on the original grid of the interval.
+// It's a no-op if the scheduler is terminated.
+func (mjob *ManagedJob) Resume() {
+	s := mjob.owner
+	s.exec(func(jobs *jobQueue) {
+		if mjob.index >= 0 && mjob.index < len(*jobs) && (*jobs)[mjob.index] == mjob {
+			s.resumeJob(mjob, jobs)
+		}
+	})
+}
+
+// LastLatency returns how late the last fire of the job was
+// relative to its scheduled time.
+func (mjob *ManagedJob) LastLatency() time.Duration {
+	return time.Duration(atomic.LoadInt64(&mjob.latency))
+}
+
+// NextComputeTime returns the cumulative time spent computing the next times
+// of the job, by the registration and the run loop. It helps to identify
+// the pathological schedules.
+func (mjob *ManagedJob) NextComputeTime() time.Duration {
+	return time.Duration(atomic.LoadInt64(&mjob.computeTime))
 }
 
 // Tag returns the tag of the job.
@@ -60,9 +251,39 @@ func (mjob *ManagedJob) Tag() interface{} {
 
 // Schelule returns the schedule of the job.
 func (mjob *ManagedJob) Schelule() Schedule {
+	mjob.mu.Lock()
+	defer mjob.mu.Unlock()
 	return mjob.schelule
 }
 
+// ScheduleString returns the normalized cron spec of the job's schedule, or
+// the description of a composite schedule like `union(0 0 * * *, 0 12 * * *)`,
+// which ParseSchedule parses back to an equal schedule.
+// It returns empty if the schedule isn't composed of cron expressions.
+func (mjob *ManagedJob) ScheduleString() string {
+	return scheduleString(mjob.Schelule())
+}
+
+// Value returns the value associated with key by WithJobValue,
+// or nil if there is no such value.
+func (mjob *ManagedJob) Value(key interface{}) interface{} {
+	return mjob.values[key]
+}
+
+// Labels returns a copy of the labels attached by WithLabels.
+func (mjob *ManagedJob) Labels() map[string]string {
+	labels := make(map[string]string, len(mjob.labels))
+	for key, val := range mjob.labels {
+		labels[key] = val
+	}
+	return labels
+}
+
+// Priority returns the priority set by WithPriority.
+func (mjob *ManagedJob) Priority() int {
+	return mjob.priority
+}
+
 // Job return the executive job  of the job.
 func (mjob *ManagedJob) Job() Job {
 	return mjob.job
@@ -83,6 +304,108 @@ func (mjob *ManagedJob) NextTime() time.Time {
 	return mjob.nextTime.get().In(mjob.postTime.Location())
 }
 
+// WaitNext waits for the next run of the job to complete.
+// It returns ctx.Err() if ctx is done first, or an error if the job
+// is removed from the scheduler before it runs.
+func (mjob *ManagedJob) WaitNext(ctx context.Context) error {
+	mjob.mu.Lock()
+	done, dead := mjob.done, mjob.dead
+	mjob.mu.Unlock()
+
+	select {
+	case <-done:
+		return nil
+	case <-dead:
+		return errors.New("job is removed before it runs")
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Preview returns the next n execution times of the job,
+// starting from its next execution time. The job is not changed,
+// a StatefulSchedule is cloned on the run loop before calculating the times.
+// It returns nil if the scheduler is terminated.
+func (mjob *ManagedJob) Preview(n int) []time.Time {
+	if n <= 0 {
+		return nil
+	}
+
+	var next time.Time
+	var schedule Schedule
+	ok := mjob.owner.exec(func(jobs *jobQueue) {
+		next = mjob.NextTime()
+		schedule = cloneSchedule(mjob.Schelule()) // don't race with the run loop
+	})
+	if !ok || next.IsZero() {
+		return nil
+	}
+
+	times := make([]time.Time, 0, n)
+	for len(times) < n && !next.IsZero() {
+		times = append(times, next)
+		next = schedule.Next(next)
+	}
+	return times
+}
+
+func (mjob *ManagedJob) run(at time.Time) {
+	if tj, ok := mjob.job.(TimedJob); ok {
+		tj.RunAt(at)
+		return
+	}
+	if cj, ok := mjob.job.(ContextJob); ok {
+		ctx := mjob.owner.ctx
+		for key, val := range mjob.values {
+			ctx = context.WithValue(ctx, key, val)
+		}
+		cj.RunContext(ctx)
+		return
+	}
+	mjob.job.Run()
+}
+
+func (mjob *ManagedJob) runStarted() {
+	mjob.mu.Lock()
+	mjob.running++
+	mjob.mu.Unlock()
+}
+
+// runCompleted ends a run started by runStarted, the run is counted and
+// wakes WaitNext if ran, otherwise it's skipped, e.g. by the locker.
+func (mjob *ManagedJob) runCompleted(ran bool) {
+	mjob.mu.Lock()
+	mjob.running--
+	if ran {
+		mjob.runs++
+		close(mjob.done)
+		mjob.done = make(chan struct{})
+	}
+	if mjob.removed && mjob.running == 0 {
+		mjob.closeDead()
+	}
+	mjob.mu.Unlock()
+}
+
+func (mjob *ManagedJob) setRemoved() {
+	mjob.mu.Lock()
+	if !mjob.removed {
+		mjob.removed = true
+		if mjob.running == 0 {
+			mjob.closeDead()
+		}
+	}
+	mjob.mu.Unlock()
+}
+
+// closeDead closes the dead channel once, it's called with mu held.
+func (mjob *ManagedJob) closeDead() {
+	if !mjob.deadClosed {
+		mjob.deadClosed = true
+		close(mjob.dead)
+	}
+}
+
 func (mjob *ManagedJob) setNext(next time.Time) {
 	mjob.prevTime.set(mjob.next)
 	mjob.next = next
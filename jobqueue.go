@@ -14,8 +14,16 @@ type jobQueue []*ManagedJob
 
 func (jobs jobQueue) Len() int { return len(jobs) }
 
+// Less orders the jobs by the next time, the jobs at the same time
+// by the priority (higher first) and then by the registration sequence.
 func (jobs jobQueue) Less(i, j int) bool {
-	return jobs[i].next.Before(jobs[j].next)
+	if !jobs[i].next.Equal(jobs[j].next) {
+		return jobs[i].next.Before(jobs[j].next)
+	}
+	if jobs[i].priority != jobs[j].priority {
+		return jobs[i].priority > jobs[j].priority
+	}
+	return jobs[i].seq < jobs[j].seq
 }
 
 func (jobs jobQueue) Swap(i, j int) {
@@ -46,3 +54,12 @@ func (jobs *jobQueue) updateNext(job *ManagedJob, next time.Time) {
 	job.setNext(next)
 	heap.Fix(jobs, job.index)
 }
+
+// countDue returns the count of jobs due at or before the specified time,
+// in the subtree rooted at i.
+func (jobs jobQueue) countDue(at time.Time, i int) int {
+	if i >= len(jobs) || jobs[i].next.After(at) {
+		return 0
+	}
+	return 1 + jobs.countDue(at, 2*i+1) + jobs.countDue(at, 2*i+2)
+}
@@ -0,0 +1,86 @@
+// Copyright (c) 2019,CAO HONGJU. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package scheduler
+
+import (
+	"container/heap"
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// assertHeap asserts that no job is due before its parent in the heap,
+// so the root is the earliest.
+func assertHeap(t *testing.T, times []time.Time) bool {
+	for i := 1; i < len(times); i++ {
+		if parent := (i - 1) / 2; times[i].Before(times[parent]) {
+			return assert.Fail(t, "heap invariant violated",
+				"job %d at %v is due before its parent at %v", i, times[i], times[parent])
+		}
+	}
+	return true
+}
+
+func TestJobQueue_UpdateNext(t *testing.T) {
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	rnd := rand.New(rand.NewSource(1))
+	random := func() time.Time {
+		return start.Add(time.Duration(rnd.Intn(1000)) * time.Second)
+	}
+
+	jobs := make(jobQueue, 0, 32)
+	for i := 0; i < 32; i++ {
+		heap.Push(&jobs, &ManagedJob{next: random(), seq: uint64(i)})
+	}
+
+	next := func() []time.Time {
+		times := make([]time.Time, len(jobs))
+		for i, j := range jobs {
+			times[i] = j.next
+		}
+		return times
+	}
+	for i := 0; i < 200; i++ {
+		j := jobs[rnd.Intn(len(jobs))]
+		jobs.updateNext(j, random())
+		if !assertHeap(t, next()) {
+			return
+		}
+		for _, other := range jobs {
+			assert.False(t, other.next.Before(jobs[0].next), "the root is the earliest")
+		}
+	}
+}
+
+func TestScheduler_QueueOrder(t *testing.T) {
+	s := NewManual(WithLocation(time.UTC))
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	s.Advance(start)
+
+	s.PeriodFunc(7*time.Minute, 7*time.Minute, func() {}, nil)
+	s.PeriodFunc(5*time.Minute, 5*time.Minute, func() {}, nil)
+	s.PeriodFunc(3*time.Minute, 3*time.Minute, func() {}, nil)
+	s.CronFunc("0 */4 * * * *", func() {}, nil)
+	s.AfterFunc(11*time.Minute, func() {}, nil)
+
+	times := queueNextTimes(s)
+	assert.Equal(t, 5, len(times))
+	assertHeap(t, times)
+	assert.Equal(t, start.Add(3*time.Minute), times[0])
+
+	for minute := 1; minute <= 60; minute++ {
+		now := start.Add(time.Duration(minute) * time.Minute)
+		s.Advance(now)
+		times = queueNextTimes(s)
+		if !assertHeap(t, times) {
+			return
+		}
+		assert.True(t, times[0].After(now), "minute %d", minute)
+		assert.Equal(t, s.Jobs()[0].NextTime(), times[0], "minute %d", minute)
+	}
+	assert.Equal(t, 4, len(times))
+}
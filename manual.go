@@ -0,0 +1,87 @@
+// Copyright (c) 2019,CAO HONGJU. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package scheduler
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// manualQueue holds the jobs of a Scheduler created by NewManual,
+// which are guarded by mu instead of the run loop.
+type manualQueue struct {
+	mu      sync.Mutex
+	jobs    jobQueue
+	now     lockedTime // the virtual time
+	pending []func()   // the runs dispatched by Advance
+}
+
+// NewManual returns a Scheduler without the run loop goroutine and timers,
+// it's driven by Advance, e.g. in a discrete-event simulation with virtual time.
+//
+// The current time of the Scheduler is the time given to the last Advance,
+// initially the time NewManual is called. Shutdown is a no-op.
+func NewManual(options ...Option) *Scheduler {
+	s := &Scheduler{
+		wg:     &sync.WaitGroup{},
+		idle:   1,
+		loc:    time.Local,
+		clock:  systemClock{},
+		manual: &manualQueue{},
+	}
+
+	for _, option := range options {
+		option.apply(s)
+	}
+
+	if s.ctx == nil {
+		s.ctx, s.cancel = context.WithCancel(context.Background())
+	}
+
+	if s.panicHandler.Load() == nil {
+		s.panicHandler.Store(PanicHandler(defaultPanicHandle))
+	}
+
+	s.manual.now.set(time.Now())
+	return s
+}
+
+// Advance sets the current time of the Scheduler created by NewManual to now,
+// and runs the jobs due at or before now on the caller's goroutine, in the order
+// of their times. It returns after all the runs complete.
+//
+// It panics if the Scheduler is not created by NewManual.
+func (s *Scheduler) Advance(now time.Time) {
+	if s.manual == nil {
+		panic("scheduler: Advance called on the scheduler not created by NewManual")
+	}
+
+	for _, run := range s.advance(now) {
+		run()
+	}
+}
+
+func (s *Scheduler) advance(now time.Time) (pending []func()) {
+	mq := s.manual
+	mq.mu.Lock()
+	defer mq.mu.Unlock()
+
+	mq.now.set(now)
+	s.runExpiredJobs(now.In(s.loc), &mq.jobs)
+	s.publishCount(len(mq.jobs))
+	pending, mq.pending = mq.pending, nil
+	return
+}
+
+// execManual runs the command with the jobs of the Scheduler created by NewManual.
+func (s *Scheduler) execManual(cmd func(jobs *jobQueue)) {
+	mq := s.manual
+	mq.mu.Lock()
+	defer mq.mu.Unlock()
+
+	cmd(&mq.jobs)
+	s.publishCount(len(mq.jobs))
+}
@@ -0,0 +1,223 @@
+// Copyright (c) 2019,CAO HONGJU. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package scheduler
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/cnotch/scheduler/cron"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewManual(t *testing.T) {
+	s := NewManual(WithLocation(time.UTC))
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	s.Advance(start)
+
+	// run on the caller's goroutine, no locking needed
+	var hourly, daily, once int
+	s.PeriodFunc(time.Hour, time.Hour, func() { hourly++ }, nil)
+	s.CronFunc("0 0 12 * * *", func() { daily++ }, nil)
+	s.AfterFunc(90*time.Minute, func() { once++ }, nil)
+	assert.Equal(t, 3, s.Count())
+	assert.Equal(t, 3, len(s.Jobs()))
+
+	s.Advance(start.Add(59 * time.Minute))
+	assert.Equal(t, 0, hourly)
+
+	s.Advance(start.Add(time.Hour))
+	assert.Equal(t, 1, hourly)
+	assert.Equal(t, 0, once)
+
+	s.Advance(start.Add(2 * time.Hour))
+	assert.Equal(t, 2, hourly)
+	assert.Equal(t, 1, once)
+	assert.Equal(t, 2, s.Count())
+
+	s.Advance(start.Add(48 * time.Hour))
+	assert.Equal(t, 48, hourly)
+	assert.Equal(t, 2, daily)
+	assert.Equal(t, 1, once)
+
+	// the virtual time is the current time
+	assert.Equal(t, 0, s.DueCount(start.Add(48*time.Hour)))
+	assert.Equal(t, []time.Time{start.Add(12 * time.Hour), start.Add(36 * time.Hour)},
+		s.MissedSince(cron.MustParse("0 0 12 * * *"), start))
+
+	s.Shutdown() // no-op
+	assert.False(t, s.Terminated())
+	s.Advance(start.Add(49 * time.Hour))
+	assert.Equal(t, 49, hourly)
+}
+
+func TestNewManual_Cancel(t *testing.T) {
+	s := NewManual()
+	start := time.Now()
+
+	var counter int
+	var self *ManagedJob
+	self, _ = s.PeriodFunc(time.Minute, time.Minute, func() {
+		counter++
+		if counter == 2 {
+			self.Cancel() // in the run
+		}
+	}, nil)
+	other, _ := s.PeriodFunc(time.Minute, time.Minute, func() {}, nil)
+
+	s.Advance(start.Add(10 * time.Minute))
+	assert.Equal(t, 10, counter) // the catch-up runs were dispatched before the cancel
+	s.Advance(start.Add(20 * time.Minute))
+	assert.Equal(t, 10, counter)
+
+	assert.True(t, other.CancelOK())
+	assert.False(t, other.CancelOK())
+	assert.Equal(t, 0, s.Count())
+
+	assert.Panics(t, func() { New().Advance(start) })
+}
+
+func TestManagedJob_HasRun(t *testing.T) {
+	s := NewManual(WithLocation(time.UTC))
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	s.Advance(start)
+
+	mjob, err := s.PeriodFunc(time.Hour, time.Hour, func() {}, nil)
+	assert.NoError(t, err)
+	assert.False(t, mjob.HasRun())
+
+	s.Advance(start.Add(59 * time.Minute))
+	assert.False(t, mjob.HasRun())
+
+	s.Advance(start.Add(time.Hour))
+	assert.True(t, mjob.HasRun())
+
+	other, _ := s.PeriodFunc(time.Hour, time.Hour, func() {}, nil)
+	other.RunAt(time.Time{}) // ran at the zero time
+	assert.True(t, other.HasRun())
+	assert.True(t, other.PrevTime().IsZero())
+}
+
+func TestScheduler_ScheduleUntilSuccess(t *testing.T) {
+	s := NewManual(WithLocation(time.UTC))
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	s.Advance(start)
+
+	var runs int
+	mjob, err := s.ScheduleUntilSuccess(cron.MustParse("0 * * * *"), FallibleJobFunc(func() error {
+		runs++
+		if runs < 3 {
+			return errors.New("not yet")
+		}
+		return nil
+	}), "retry")
+	assert.NoError(t, err)
+
+	for i := 1; i <= 5; i++ {
+		s.Advance(start.Add(time.Duration(i) * time.Hour))
+	}
+	assert.Equal(t, 3, runs)
+	assert.Equal(t, 3, mjob.RunCount())
+	assert.Equal(t, 0, s.Count())
+	assert.False(t, mjob.CancelOK(), "removed")
+}
+
+func TestScheduler_RescheduleWhere(t *testing.T) {
+	s := NewManual(WithLocation(time.UTC))
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	s.Advance(start)
+
+	var low, high, once int
+	s.PostFunc(&periodSchedule{initialDelay: time.Minute, period: time.Minute}, func() { low++ }, "low",
+		WithLabels(map[string]string{"priority": "low"}))
+	s.PostFunc(&periodSchedule{initialDelay: time.Minute, period: time.Minute}, func() { high++ }, "high")
+	s.PostFunc(&afterSchedule{delay: time.Hour}, func() { once++ }, "once",
+		WithLabels(map[string]string{"priority": "low"}))
+
+	s.Advance(start.Add(10 * time.Minute))
+	assert.Equal(t, 10, low)
+	assert.Equal(t, 10, high)
+
+	s.RescheduleWhere(func(j *ManagedJob) bool {
+		return j.Labels()["priority"] == "low"
+	}, func(old Schedule) Schedule {
+		if ps, ok := old.(*periodSchedule); ok {
+			return &periodSchedule{called: true, period: 2 * ps.period}
+		}
+		return old
+	})
+
+	s.Advance(start.Add(30 * time.Minute))
+	assert.Equal(t, 20, low, "doubled period")
+	assert.Equal(t, 30, high, "unchanged")
+
+	// the job whose new schedule never fires is removed
+	assert.Equal(t, 3, s.Count())
+	s.RescheduleWhere(func(j *ManagedJob) bool {
+		return j.Tag() == "once"
+	}, func(Schedule) Schedule { return UnionAll() })
+	assert.Equal(t, 2, s.Count())
+	s.Advance(start.Add(2 * time.Hour))
+	assert.Equal(t, 0, once)
+}
+
+func TestScheduler_RescheduleWhereConcurrentReads(t *testing.T) {
+	s := New()
+	defer s.Shutdown()
+
+	mjob, _ := s.CronFunc("0 0 * * * *", func() {}, "job")
+	specs := []*cron.Expression{cron.MustParse("0 0 * * * *"), cron.MustParse("0 30 * * * *")}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			s.RescheduleWhere(func(*ManagedJob) bool { return true },
+				func(Schedule) Schedule { return specs[i%2] })
+		}
+	}()
+	for i := 0; i < 100; i++ {
+		assert.NotNil(t, mjob.Schelule())
+		assert.NotEmpty(t, mjob.ScheduleString())
+	}
+	<-done
+	assert.Equal(t, "0 30 * * * *", mjob.ScheduleString())
+}
+
+func TestScheduler_RelativeTo(t *testing.T) {
+	s := NewManual(WithLocation(time.UTC))
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	s.Advance(start)
+
+	aFires, afterFires, beforeFires := &timedJob{}, &timedJob{}, &timedJob{}
+	a, _ := s.Cron("0 0 */2 * * *", aFires, "a")
+	after, err := s.RelativeTo(a, 5*time.Minute, afterFires, "after")
+	assert.NoError(t, err)
+	before, _ := s.RelativeTo(a, -10*time.Minute, beforeFires, "before")
+	assert.Equal(t, start.Add(2*time.Hour+5*time.Minute), after.NextTime())
+	assert.Equal(t, start.Add(110*time.Minute), before.NextTime())
+
+	for m := 5; m <= 6*60+5; m += 5 {
+		s.Advance(start.Add(time.Duration(m) * time.Minute))
+	}
+	assert.Len(t, aFires.times, 3)
+	assert.Len(t, afterFires.times, 3)
+	assert.Len(t, beforeFires.times, 3)
+	for i, at := range aFires.times {
+		assert.Equal(t, at.Add(5*time.Minute), afterFires.times[i])
+		assert.Equal(t, at.Add(-10*time.Minute), beforeFires.times[i])
+	}
+
+	// the relative jobs terminate with a
+	s.Advance(start.Add(7*time.Hour + 55*time.Minute))
+	a.Cancel()
+	s.Advance(start.Add(10 * time.Hour))
+	assert.Len(t, afterFires.times, 3)
+	assert.Len(t, beforeFires.times, 4)
+	assert.Equal(t, 0, s.Count())
+
+	_, err = NewManual().RelativeTo(a, time.Minute, JobFunc(func() {}), nil)
+	assert.Error(t, err)
+}
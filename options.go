@@ -6,6 +6,7 @@ package scheduler
 
 import (
 	"context"
+	"sync"
 	"time"
 )
 
@@ -35,6 +36,15 @@ func WithLocation(location *time.Location) Option {
 	})
 }
 
+// WithClock configures the clock of the Scheduler, the system clock by default.
+// The gaps of the interval jobs, e.g. Period and After, follow the monotonic time
+// of the clock, while the cron jobs follow the wall clock.
+func WithClock(clock Clock) Option {
+	return optionFunc(func(s *Scheduler) {
+		s.clock = clock
+	})
+}
+
 // WithPanicHandler configures the panic exception handler.
 func WithPanicHandler(panicHandler PanicHandler) Option {
 	return optionFunc(func(s *Scheduler) {
@@ -44,3 +54,209 @@ func WithPanicHandler(panicHandler PanicHandler) Option {
 		s.panicHandler.Store(panicHandler)
 	})
 }
+
+// WithOnAdd configures the hook called when a job is added to the Scheduler.
+// The hook is called on the run loop, it must not block or
+// call the methods of the Scheduler.
+func WithOnAdd(onAdd func(*ManagedJob)) Option {
+	return optionFunc(func(s *Scheduler) {
+		s.onAdd = onAdd
+	})
+}
+
+// WithOnRemove configures the hook called when a job is removed from the Scheduler,
+// because it's cancelled, replaced or its schedule is exhausted. It's not called
+// when the Scheduler shutdowns. The hook is called on the run loop, it must not
+// block or call the methods of the Scheduler.
+func WithOnRemove(onRemove func(*ManagedJob)) Option {
+	return optionFunc(func(s *Scheduler) {
+		s.onRemove = onRemove
+	})
+}
+
+// WithDeadLetter configures the hook called when a job is removed from the Scheduler
+// for a failure, with the reason DeadLetterPanic or DeadLetterStalled. It's not called
+// for the normal removals, e.g. the schedule is exhausted, which are reported by
+// WithOnRemove. The hook is called on the run loop, it must not block or
+// call the methods of the Scheduler.
+func WithDeadLetter(deadLetter func(job *ManagedJob, reason string)) Option {
+	return optionFunc(func(s *Scheduler) {
+		s.deadLetter = deadLetter
+	})
+}
+
+// WithLocker configures the locker acquired before running a job with its tag,
+// the run is skipped if the lock isn't acquired.
+func WithLocker(locker Locker) Option {
+	return optionFunc(func(s *Scheduler) {
+		s.locker = locker
+	})
+}
+
+// WithPerTagConcurrency configures the max concurrent runs of the jobs with
+// the same tag, limit returns the max for the tag, 0 means no limit.
+// A run exceeding the max of its tag is skipped, not queued.
+// The tags must be comparable.
+func WithPerTagConcurrency(limit func(tag interface{}) int) Option {
+	return optionFunc(func(s *Scheduler) {
+		s.tagLimit = &tagLimit{
+			limit:   limit,
+			running: make(map[interface{}]int),
+		}
+	})
+}
+
+// WithInlineExecution configures the Scheduler to run the jobs on its run loop
+// one by one, instead of a goroutine per run. So the runs are serialized,
+// and the jobs can share the data without locking.
+//
+// A slow job blocks the run loop, delays the other jobs and all the methods
+// of the Scheduler. A job must not call the methods of the Scheduler or
+// its ManagedJob, which wait for the run loop, e.g. Cancel, or it deadlocks.
+func WithInlineExecution() Option {
+	return optionFunc(func(s *Scheduler) {
+		s.inline = true
+	})
+}
+
+// WithExecutor configures the executor which runs the jobs, e.g. a managed
+// goroutine pool, instead of a goroutine per run. The executor is called on
+// the run loop with the work of a run, it must not block and must run every
+// work eventually, otherwise ShutdownAndWait waits forever. If the executor
+// panics, the work is dropped, and the job is removed as DeadLetterPanic.
+func WithExecutor(executor func(work func())) Option {
+	return optionFunc(func(s *Scheduler) {
+		s.executor = executor
+	})
+}
+
+// WithRateLimit configures the Scheduler to dispatch no more than r jobs
+// per interval. The excess fires are delayed to the next interval,
+// rather than dropped.
+func WithRateLimit(r int, per time.Duration) Option {
+	return optionFunc(func(s *Scheduler) {
+		if r <= 0 || per <= 0 {
+			return
+		}
+		s.rate.limit = r
+		s.rate.per = per
+	})
+}
+
+// WithMinWake configures the min duration the run loop sleeps before the next
+// due job, so the closely-spaced jobs are dispatched in batches with fewer wakes.
+// It trades the latency for the throughput, a job may fire up to d late.
+// The jobs already due are dispatched without sleeping.
+func WithMinWake(d time.Duration) Option {
+	return optionFunc(func(s *Scheduler) {
+		if d > 0 {
+			s.minWake = d
+		}
+	})
+}
+
+// WithManualTick configures the Scheduler to be driven by manual ticks,
+// it's useful for deterministic tests.
+//
+// The returned function processes the jobs due at or before now, and returns
+// after these jobs are completed. When enabled, the manual ticks are the only
+// source of fires. It's a no-op before the option is applied by New,
+// or after the Scheduler is terminated.
+func WithManualTick() (Option, func(now time.Time)) {
+	var mu sync.Mutex
+	var schd *Scheduler
+	ticks := make(chan manualTick)
+	option := optionFunc(func(s *Scheduler) {
+		mu.Lock()
+		schd = s
+		mu.Unlock()
+		s.tick = ticks
+	})
+
+	tick := func(now time.Time) {
+		mu.Lock()
+		s := schd
+		mu.Unlock()
+		if s == nil { // not wired to a Scheduler yet
+			return
+		}
+
+		reply := make(chan *sync.WaitGroup, 1)
+		select {
+		case <-s.exited:
+			return
+		case ticks <- manualTick{now: now, reply: reply}:
+		}
+		(<-reply).Wait()
+	}
+	return option, tick
+}
+
+type manualTick struct {
+	now   time.Time
+	reply chan *sync.WaitGroup
+}
+
+// A JobOption configures a job when it is posted.
+type JobOption interface {
+	applyJob(*ManagedJob)
+}
+
+// jobOptionFunc wraps a func so it satisfies the JobOption interface.
+type jobOptionFunc func(*ManagedJob)
+
+func (f jobOptionFunc) applyJob(j *ManagedJob) {
+	f(j)
+}
+
+// WithJobValue attaches the value associated with key to the job,
+// like a correlation id. The value is returned by ManagedJob.Value,
+// and carried by the context passed to ContextJob.
+func WithJobValue(key, val interface{}) JobOption {
+	return jobOptionFunc(func(j *ManagedJob) {
+		if j.values == nil {
+			j.values = make(map[interface{}]interface{})
+		}
+		j.values[key] = val
+	})
+}
+
+// WithPriority sets the priority of the job, 0 by default. The jobs due at
+// the same time are dispatched in the order of priority, higher first,
+// and then in the order they are posted.
+func WithPriority(priority int) JobOption {
+	return jobOptionFunc(func(j *ManagedJob) {
+		j.priority = priority
+	})
+}
+
+// WithLabels attaches the labels to the job for filtering, e.g. by
+// Scheduler.JobsWithLabel. The labels are copied, and immutable after the job is posted.
+func WithLabels(labels map[string]string) JobOption {
+	return jobOptionFunc(func(j *ManagedJob) {
+		if j.labels == nil {
+			j.labels = make(map[string]string, len(labels))
+		}
+		for key, val := range labels {
+			j.labels[key] = val
+		}
+	})
+}
+
+// Exclusive prevents the runs of the job from overlapping, a fire is skipped
+// rather than delayed if the prior run of the job is still active.
+// The skipped fires are counted by ManagedJob.SkippedCount and Stats.
+func Exclusive() JobOption {
+	return jobOptionFunc(func(j *ManagedJob) {
+		j.exclusive = true
+	})
+}
+
+// ResumePreservePhase keeps the Period or Repeat job on the original grid of
+// its interval when it's resumed, rather than restarting the interval from
+// the resume time, see ManagedJob.Resume.
+func ResumePreservePhase() JobOption {
+	return jobOptionFunc(func(j *ManagedJob) {
+		j.phased = true
+	})
+}
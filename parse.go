@@ -0,0 +1,138 @@
+// Copyright (c) 2019,CAO HONGJU. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package scheduler
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+const letPrefix = "let "
+
+// ParseSchedule parses the schedule composed of the cron expressions, e.g.
+//
+//	let biz = 0 9 * * MON-FRI; union(biz, minus(0 12 * * *, 0 12 * * SUN))
+//
+// The expression is a cron expression (or `@every <duration>`), a name
+// defined by a `let name = <expression>;` prelude, or one of the operations
+// union(x, y, ...), minus(x, y), intersect(x, y) and orElse(x, y).
+// The arguments are separated by a comma followed by a whitespace,
+// since the lists of cron fields contain commas without whitespaces.
+func ParseSchedule(spec string) (Schedule, error) {
+	names := make(map[string]Schedule)
+	body := strings.TrimSpace(spec)
+	for strings.HasPrefix(body, letPrefix) {
+		idx := strings.IndexByte(body, ';')
+		if idx == -1 {
+			return nil, fmt.Errorf("missing ';' after let: '%s'", body)
+		}
+		def := body[len(letPrefix):idx]
+		body = strings.TrimSpace(body[idx+1:])
+
+		idx = strings.IndexByte(def, '=')
+		if idx == -1 {
+			return nil, fmt.Errorf("missing '=' in let: '%s'", def)
+		}
+		name := strings.TrimSpace(def[:idx])
+		if !isScheduleName(name) {
+			return nil, fmt.Errorf("invalid name in let: '%s'", name)
+		}
+		if _, ok := names[name]; ok {
+			return nil, fmt.Errorf("name redefined in let: '%s'", name)
+		}
+
+		schedule, err := parseScheduleExpr(def[idx+1:], names)
+		if err != nil {
+			return nil, err
+		}
+		names[name] = schedule
+	}
+
+	if len(body) == 0 {
+		return nil, errors.New("missing schedule expression in spec: " + spec)
+	}
+	return parseScheduleExpr(body, names)
+}
+
+func parseScheduleExpr(expr string, names map[string]Schedule) (Schedule, error) {
+	expr = strings.TrimSpace(expr)
+	if len(expr) == 0 {
+		return nil, errors.New("empty schedule expression")
+	}
+
+	if idx := strings.IndexByte(expr, '('); idx != -1 {
+		if !strings.HasSuffix(expr, ")") {
+			return nil, fmt.Errorf("missing ')' in schedule expression: '%s'", expr)
+		}
+
+		args, err := splitScheduleArgs(expr[idx+1 : len(expr)-1])
+		if err != nil {
+			return nil, err
+		}
+		operands := make([]Schedule, len(args))
+		for i, arg := range args {
+			if operands[i], err = parseScheduleExpr(arg, names); err != nil {
+				return nil, err
+			}
+		}
+
+		op := strings.TrimSpace(expr[:idx])
+		switch {
+		case op == "union":
+			return UnionAll(operands...), nil
+		case op == "minus" && len(operands) == 2:
+			return Minus(operands[0], operands[1]), nil
+		case op == "intersect" && len(operands) == 2:
+			return Intersect(operands[0], operands[1]), nil
+		case op == "orElse" && len(operands) == 2:
+			return OrElse(operands[0], operands[1]), nil
+		}
+		return nil, fmt.Errorf("invalid operation in schedule expression: '%s'", expr)
+	}
+
+	if schedule, ok := names[expr]; ok {
+		return cloneSchedule(schedule), nil
+	}
+	return parseCron(expr)
+}
+
+// splitScheduleArgs splits the arguments of an operation.
+func splitScheduleArgs(s string) (args []string, err error) {
+	depth, start := 0, 0
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '(':
+			depth++
+		case ')':
+			depth--
+			if depth < 0 {
+				return nil, fmt.Errorf("unbalanced ')' in schedule expression: '%s'", s)
+			}
+		case ',':
+			if depth == 0 && i+1 < len(s) && unicode.IsSpace(rune(s[i+1])) {
+				args = append(args, s[start:i])
+				start = i + 1
+			}
+		}
+	}
+	if depth != 0 {
+		return nil, fmt.Errorf("unbalanced '(' in schedule expression: '%s'", s)
+	}
+	return append(args, s[start:]), nil
+}
+
+func isScheduleName(name string) bool {
+	if len(name) == 0 || !unicode.IsLetter(rune(name[0])) {
+		return false
+	}
+	for _, c := range name {
+		if !unicode.IsLetter(c) && !unicode.IsDigit(c) && c != '_' && c != '-' {
+			return false
+		}
+	}
+	return true
+}
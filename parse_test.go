@@ -0,0 +1,54 @@
+// Copyright (c) 2019,CAO HONGJU. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package scheduler
+
+import (
+	"testing"
+	"time"
+
+	"github.com/cnotch/scheduler/cron"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseSchedule(t *testing.T) {
+	biz := cron.MustParse("0 9 * * MON-FRI")
+	sat := cron.MustParse("0 12 * * SAT")
+	noon := cron.MustParse("0 12,18 * * *")
+	tests := []struct {
+		spec     string
+		expanded Schedule
+	}{
+		{"0 9 * * MON-FRI", biz},
+		{"let biz = 0 9 * * MON-FRI; let sat = 0 12 * * SAT; union(biz, sat)", Union(biz, sat)},
+		{"let biz = 0 9 * * MON-FRI;let sat=0 12 * * SAT;union(biz, 0 12 * * SAT)", Union(biz, sat)},
+		{"let weekend = union(0 12 * * SAT, 0 12 * * SUN); minus(0 12,18 * * *, weekend)",
+			Minus(noon, Union(sat, cron.MustParse("0 12 * * SUN")))},
+		{"let a = 0 12,18 * * *; let b = union(a, 0 9 * * MON-FRI); intersect(b, 0 12 * * SAT)", Intersect(Union(noon, biz), sat)},
+		{"union(0 9 * * MON-FRI, 0 12 * * SAT, 0 12,18 * * *)", UnionAll(biz, sat, noon)},
+	}
+
+	from := time.Date(2020, 4, 20, 0, 0, 0, 0, time.UTC)
+	for _, test := range tests {
+		sched, err := ParseSchedule(test.spec)
+		if !assert.NoError(t, err, test.spec) {
+			continue
+		}
+		next, want := from, from
+		for i := 0; i < 50; i++ {
+			next, want = sched.Next(next), test.expanded.Next(want)
+			assert.Equal(t, want, next, test.spec)
+		}
+	}
+
+	for _, spec := range []string{
+		"", "let a = 0 9 * * *", "let a = 0 9 * * *;", "let 1a = 0 9 * * *; 1a",
+		"let a = 0 9 * * *; let a = 0 9 * * *; a", "let a 0 9 * * *; a", "b",
+		"union(0 9 * * *, )", "minus(0 9 * * *)", "power(0 9 * * *, 0 9 * * *)",
+		"union(0 9 * * *, 0 9 * * *", "union(0 9 * * *, 0 9 * * *))", "union(union(0 9 * * *), 0 9 * * *",
+	} {
+		_, err := ParseSchedule(spec)
+		assert.Error(t, err, spec)
+	}
+}
@@ -0,0 +1,304 @@
+// Copyright (c) 2019,CAO HONGJU. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package scheduler
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The frequencies of RRULE.
+const (
+	freqDaily = iota
+	freqWeekly
+	freqMonthly
+	freqYearly
+)
+
+// maxRRulePeriods bounds the periods searched for an occurrence.
+const maxRRulePeriods = 1 << 16
+
+var rruleFreqs = map[string]int{
+	"DAILY":   freqDaily,
+	"WEEKLY":  freqWeekly,
+	"MONTHLY": freqMonthly,
+	"YEARLY":  freqYearly,
+}
+
+var rruleWeekdays = map[string]time.Weekday{
+	"SU": time.Sunday,
+	"MO": time.Monday,
+	"TU": time.Tuesday,
+	"WE": time.Wednesday,
+	"TH": time.Thursday,
+	"FR": time.Friday,
+	"SA": time.Saturday,
+}
+
+// ParseRRULE returns the schedule of the RFC 5545 recurrence rule,
+// which starts at dtstart. The rule may be prefixed with "RRULE:".
+//
+// The supported subset is FREQ (DAILY, WEEKLY, MONTHLY or YEARLY), INTERVAL,
+// BYDAY, BYMONTHDAY, UNTIL and COUNT. The ordinal BYDAY values like 1MO or -1FR
+// are only allowed with FREQ=MONTHLY. The time of day of the occurrences
+// is taken from dtstart, and dtstart is an occurrence only if it matches the rule.
+func ParseRRULE(rule string, dtstart time.Time) (Schedule, error) {
+	rule = strings.TrimPrefix(strings.TrimSpace(rule), "RRULE:")
+
+	r := &rrule{freq: -1, interval: 1, dtstart: dtstart}
+	for _, part := range strings.Split(rule, ";") {
+		idx := strings.IndexByte(part, '=')
+		if idx == -1 {
+			return nil, fmt.Errorf("syntax error in rrule: '%s'", part)
+		}
+
+		name, value := strings.ToUpper(part[:idx]), part[idx+1:]
+		var ok bool
+		switch name {
+		case "FREQ":
+			r.freq, ok = rruleFreqs[strings.ToUpper(value)]
+		case "INTERVAL":
+			r.interval, ok = atoiRange(value, 1, 1<<20)
+		case "COUNT":
+			r.count, ok = atoiRange(value, 1, 1<<20)
+		case "UNTIL":
+			r.until, ok = parseRRuleTime(value, dtstart.Location())
+		case "BYDAY":
+			r.byDay, ok = parseRRuleDays(value)
+		case "BYMONTHDAY":
+			r.byMonthDay, ok = parseRRuleMonthDays(value)
+		default:
+			return nil, fmt.Errorf("unsupported rrule part: '%s'", name)
+		}
+		if !ok {
+			return nil, fmt.Errorf("invalid value of %s in rrule: '%s'", name, value)
+		}
+	}
+
+	if r.freq == -1 {
+		return nil, fmt.Errorf("FREQ is required in rrule")
+	}
+	if r.count > 0 && !r.until.IsZero() {
+		return nil, fmt.Errorf("COUNT and UNTIL must not occur in the same rrule")
+	}
+	if len(r.byMonthDay) > 0 && r.freq != freqDaily && r.freq != freqMonthly {
+		return nil, fmt.Errorf("BYMONTHDAY is only supported with FREQ=DAILY or FREQ=MONTHLY")
+	}
+	if len(r.byDay) > 0 && r.freq == freqYearly {
+		return nil, fmt.Errorf("BYDAY is not supported with FREQ=YEARLY")
+	}
+	for _, day := range r.byDay {
+		if day.n != 0 && r.freq != freqMonthly {
+			return nil, fmt.Errorf("ordinal BYDAY is only supported with FREQ=MONTHLY")
+		}
+	}
+	return r, nil
+}
+
+type rruleDay struct {
+	weekday time.Weekday
+	n       int // the n-th weekday of the month, 0 for every
+}
+
+type rrule struct {
+	freq       int
+	interval   int
+	count      int
+	until      time.Time
+	byDay      []rruleDay
+	byMonthDay []int
+	dtstart    time.Time
+}
+
+func (r *rrule) Next(t time.Time) time.Time {
+	period := 0
+	if r.count == 0 { // no need to count from dtstart
+		period = r.periodOf(t)
+	}
+
+	index := 0
+	for i := 0; i < maxRRulePeriods; i, period = i+1, period+1 {
+		for _, occurrence := range r.occurrences(period) {
+			if occurrence.Before(r.dtstart) {
+				continue
+			}
+			index++
+			if r.count > 0 && index > r.count {
+				return time.Time{}
+			}
+			if !r.until.IsZero() && occurrence.After(r.until) {
+				return time.Time{}
+			}
+			if occurrence.After(t) {
+				return occurrence
+			}
+		}
+	}
+	return time.Time{}
+}
+
+// periodOf returns the period before the one which contains t.
+func (r *rrule) periodOf(t time.Time) int {
+	if !t.After(r.dtstart) {
+		return 0
+	}
+
+	var units int
+	switch r.freq {
+	case freqDaily:
+		units = int(t.Sub(r.dtstart).Hours() / 24)
+	case freqWeekly:
+		units = int(t.Sub(r.dtstart).Hours() / (24 * 7))
+	case freqMonthly:
+		units = (t.Year()-r.dtstart.Year())*12 + int(t.Month()-r.dtstart.Month())
+	case freqYearly:
+		units = t.Year() - r.dtstart.Year()
+	}
+
+	period := units/r.interval - 1
+	if period < 0 {
+		return 0
+	}
+	return period
+}
+
+// occurrences returns the occurrences in the given period, in order.
+func (r *rrule) occurrences(period int) (times []time.Time) {
+	y, m, d := r.dtstart.Date()
+	at := func(y int, m time.Month, d int) time.Time {
+		return time.Date(y, m, d, r.dtstart.Hour(), r.dtstart.Minute(),
+			r.dtstart.Second(), r.dtstart.Nanosecond(), r.dtstart.Location())
+	}
+
+	switch r.freq {
+	case freqDaily:
+		day := at(y, m, d+period*r.interval)
+		if r.matchDay(day) {
+			times = append(times, day)
+		}
+
+	case freqWeekly: // the week starts on Monday
+		monday := d - (int(r.dtstart.Weekday())+6)%7 + period*r.interval*7
+		for i := 0; i < 7; i++ {
+			day := at(y, m, monday+i)
+			if len(r.byDay) == 0 && day.Weekday() == r.dtstart.Weekday() ||
+				len(r.byDay) > 0 && r.matchDay(day) {
+				times = append(times, day)
+			}
+		}
+
+	case freqMonthly:
+		first := time.Date(y, m+time.Month(period*r.interval), 1, 0, 0, 0, 0, time.UTC)
+		days := daysIn(first.Year(), first.Month())
+		if len(r.byDay) == 0 && len(r.byMonthDay) == 0 {
+			if d <= days {
+				times = append(times, at(first.Year(), first.Month(), d))
+			}
+			break
+		}
+		for i := 1; i <= days; i++ {
+			day := at(first.Year(), first.Month(), i)
+			if r.matchDay(day) {
+				times = append(times, day)
+			}
+		}
+
+	case freqYearly:
+		year := y + period*r.interval
+		if m != time.February || d != 29 || daysIn(year, m) == 29 {
+			times = append(times, at(year, m, d))
+		}
+	}
+	return
+}
+
+// matchDay reports whether the day matches BYDAY and BYMONTHDAY.
+func (r *rrule) matchDay(day time.Time) bool {
+	days := daysIn(day.Year(), day.Month())
+	if len(r.byMonthDay) > 0 {
+		matched := false
+		for _, monthDay := range r.byMonthDay {
+			if monthDay == day.Day() || monthDay == day.Day()-days-1 {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+
+	if len(r.byDay) == 0 {
+		return true
+	}
+	for _, byDay := range r.byDay {
+		if byDay.weekday != day.Weekday() {
+			continue
+		}
+		if byDay.n == 0 ||
+			byDay.n > 0 && (day.Day()-1)/7+1 == byDay.n ||
+			byDay.n < 0 && (days-day.Day())/7+1 == -byDay.n {
+			return true
+		}
+	}
+	return false
+}
+
+func daysIn(year int, month time.Month) int {
+	return time.Date(year, month+1, 0, 0, 0, 0, 0, time.UTC).Day()
+}
+
+func atoiRange(s string, min, max int) (int, bool) {
+	n, err := strconv.Atoi(s)
+	return n, err == nil && n >= min && n <= max
+}
+
+func parseRRuleTime(s string, loc *time.Location) (time.Time, bool) {
+	if t, err := time.Parse("20060102T150405Z", s); err == nil { // UTC
+		return t, true
+	}
+	if t, err := time.ParseInLocation("20060102T150405", s, loc); err == nil {
+		return t, true
+	}
+	if t, err := time.ParseInLocation("20060102", s, loc); err == nil { // the whole day
+		return t.AddDate(0, 0, 1).Add(-time.Nanosecond), true
+	}
+	return time.Time{}, false
+}
+
+func parseRRuleDays(s string) (days []rruleDay, ok bool) {
+	for _, value := range strings.Split(strings.ToUpper(s), ",") {
+		if len(value) < 2 {
+			return nil, false
+		}
+
+		weekday, ok := rruleWeekdays[value[len(value)-2:]]
+		if !ok {
+			return nil, false
+		}
+		day := rruleDay{weekday: weekday}
+		if len(value) > 2 {
+			day.n, ok = atoiRange(strings.TrimPrefix(value[:len(value)-2], "+"), -5, 5)
+			if !ok || day.n == 0 {
+				return nil, false
+			}
+		}
+		days = append(days, day)
+	}
+	return days, true
+}
+
+func parseRRuleMonthDays(s string) (monthDays []int, ok bool) {
+	for _, value := range strings.Split(s, ",") {
+		n, ok := atoiRange(value, -31, 31)
+		if !ok || n == 0 {
+			return nil, false
+		}
+		monthDays = append(monthDays, n)
+	}
+	return monthDays, true
+}
@@ -0,0 +1,72 @@
+// Copyright (c) 2019,CAO HONGJU. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package scheduler
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseRRULE(t *testing.T) {
+	layout := "Mon 2006-01-02 15:04"
+	tests := []struct {
+		rule    string
+		dtstart string
+		nexts   []string
+	}{
+		{"FREQ=WEEKLY;BYDAY=MO,WE,FR;COUNT=5", "Mon 2020-04-20 09:00",
+			[]string{"Mon 2020-04-20 09:00", "Wed 2020-04-22 09:00", "Fri 2020-04-24 09:00", "Mon 2020-04-27 09:00", "Wed 2020-04-29 09:00", ""}},
+		{"RRULE:FREQ=WEEKLY;INTERVAL=2;BYDAY=TU,TH;UNTIL=20200521T100000Z", "Tue 2020-04-21 10:00",
+			[]string{"Tue 2020-04-21 10:00", "Thu 2020-04-23 10:00", "Tue 2020-05-05 10:00", "Thu 2020-05-07 10:00", "Tue 2020-05-19 10:00", "Thu 2020-05-21 10:00", ""}},
+		{"FREQ=WEEKLY", "Wed 2020-04-22 08:30",
+			[]string{"Wed 2020-04-22 08:30", "Wed 2020-04-29 08:30", "Wed 2020-05-06 08:30"}},
+		{"FREQ=MONTHLY;BYMONTHDAY=1,15,-1", "Wed 2020-01-01 12:00",
+			[]string{"Wed 2020-01-01 12:00", "Wed 2020-01-15 12:00", "Fri 2020-01-31 12:00", "Sat 2020-02-01 12:00", "Sat 2020-02-15 12:00", "Sat 2020-02-29 12:00", "Sun 2020-03-01 12:00"}},
+		{"FREQ=MONTHLY;BYMONTHDAY=31;COUNT=3", "Fri 2020-01-31 00:00",
+			[]string{"Fri 2020-01-31 00:00", "Tue 2020-03-31 00:00", "Sun 2020-05-31 00:00", ""}},
+		{"FREQ=MONTHLY;BYDAY=-1FR", "Wed 2020-01-01 18:00",
+			[]string{"Fri 2020-01-31 18:00", "Fri 2020-02-28 18:00", "Fri 2020-03-27 18:00"}},
+		{"FREQ=MONTHLY;INTERVAL=3", "Fri 2020-01-31 07:00",
+			[]string{"Fri 2020-01-31 07:00", "Fri 2020-07-31 07:00", "Sat 2020-10-31 07:00"}},
+		{"FREQ=DAILY;INTERVAL=2;BYDAY=SA,SU;UNTIL=20200510", "Wed 2020-04-01 06:00",
+			[]string{"Sun 2020-04-05 06:00", "Sat 2020-04-11 06:00", "Sun 2020-04-19 06:00", "Sat 2020-04-25 06:00", "Sun 2020-05-03 06:00", "Sat 2020-05-09 06:00", ""}},
+		{"FREQ=YEARLY", "Sat 2020-02-29 00:00",
+			[]string{"Sat 2020-02-29 00:00", "Thu 2024-02-29 00:00"}},
+	}
+
+	for _, test := range tests {
+		dtstart, _ := time.Parse(layout, test.dtstart)
+		sched, err := ParseRRULE(test.rule, dtstart)
+		if !assert.NoError(t, err, test.rule) {
+			continue
+		}
+
+		next := dtstart.Add(-time.Second)
+		for _, want := range test.nexts {
+			next = sched.Next(next)
+			if want == "" {
+				assert.True(t, next.IsZero(), test.rule)
+				break
+			}
+			assert.Equal(t, want, next.Format(layout), test.rule)
+		}
+	}
+
+	// jump to a far time
+	dtstart := time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+	sched, _ := ParseRRULE("FREQ=DAILY;INTERVAL=3", dtstart)
+	assert.Equal(t, time.Date(2030, 1, 2, 12, 0, 0, 0, time.UTC), sched.Next(time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)))
+
+	for _, rule := range []string{
+		"", "INTERVAL=2", "FREQ=HOURLY", "FREQ=DAILY;INTERVAL=0", "FREQ=DAILY;WKST=MO",
+		"FREQ=DAILY;COUNT=2;UNTIL=20200101", "FREQ=WEEKLY;BYDAY=XX", "FREQ=WEEKLY;BYDAY=1MO",
+		"FREQ=MONTHLY;BYMONTHDAY=0", "FREQ=MONTHLY;BYMONTHDAY=32", "FREQ=YEARLY;BYDAY=MO",
+	} {
+		_, err := ParseRRULE(rule, dtstart)
+		assert.Error(t, err, rule)
+	}
+}
@@ -4,7 +4,14 @@
 
 package scheduler
 
-import "time"
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/cnotch/scheduler/cron"
+)
 
 // Schedule describes a job's duty cycle.
 type Schedule interface {
@@ -13,6 +20,25 @@ type Schedule interface {
 	Next(time.Time) time.Time
 }
 
+// StatefulSchedule is a Schedule whose Next changes its internal state.
+// Such a schedule must be cloned before calculating its times in advance,
+// otherwise the times seen by the Scheduler will be skipped.
+type StatefulSchedule interface {
+	Schedule
+	// Clone returns a copy of the schedule with the same state.
+	Clone() Schedule
+}
+
+// CompositeSchedule is a Schedule composed of two schedules,
+// it's returned by Union, Minus, Intersect and OrElse.
+type CompositeSchedule interface {
+	Schedule
+	// Op returns the operation, "union", "minus", "intersect" or "orElse".
+	Op() string
+	// Operands returns the left and right schedules.
+	Operands() (Schedule, Schedule)
+}
+
 // ScheduleFunc is an adapter to allow the use of ordinary functions as the Schedule interface.
 type ScheduleFunc func(time.Time) time.Time
 
@@ -29,11 +55,60 @@ func Union(l, r Schedule) Schedule {
 	}
 }
 
+// UnionAll returns the new schedule that union all the given schedules.
+// The returned schedule never fires if no schedules are given.
+func UnionAll(schedules ...Schedule) Schedule {
+	if len(schedules) == 0 {
+		return emptySchedule{}
+	}
+
+	u := schedules[0]
+	for _, s := range schedules[1:] {
+		u = Union(u, s)
+	}
+	return u
+}
+
+// ParseAny returns the union of the cron expressions in spec,
+// which are separated by '|', e.g. "0 9 * * MON-FRI | 0 12 * * SAT".
+func ParseAny(spec string) (Schedule, error) {
+	alternatives := strings.Split(spec, "|")
+	schedules := make([]Schedule, len(alternatives))
+	for i, alt := range alternatives {
+		alt = strings.TrimSpace(alt)
+		if len(alt) == 0 {
+			return nil, errors.New("empty alternative in spec: " + spec)
+		}
+
+		expr, err := cron.Parse(alt)
+		if err != nil {
+			return nil, err
+		}
+		schedules[i] = expr
+	}
+	return UnionAll(schedules...), nil
+}
+
 type union struct {
 	l Schedule
 	r Schedule
 }
 
+func (us *union) Op() string {
+	return "union"
+}
+
+func (us *union) Operands() (Schedule, Schedule) {
+	return us.l, us.r
+}
+
+func (us *union) Clone() Schedule {
+	return &union{
+		l: cloneSchedule(us.l),
+		r: cloneSchedule(us.r),
+	}
+}
+
 func (us *union) Next(t time.Time) time.Time {
 	t1 := us.l.Next(t)
 	t2 := us.r.Next(t)
@@ -62,6 +137,21 @@ type minus struct {
 	r Schedule
 }
 
+func (ms *minus) Op() string {
+	return "minus"
+}
+
+func (ms *minus) Operands() (Schedule, Schedule) {
+	return ms.l, ms.r
+}
+
+func (ms *minus) Clone() Schedule {
+	return &minus{
+		l: cloneSchedule(ms.l),
+		r: cloneSchedule(ms.r),
+	}
+}
+
 func (ms *minus) Next(t time.Time) time.Time {
 	t1 := ms.l.Next(t)
 	t2 := ms.r.Next(t)
@@ -90,25 +180,84 @@ func (ms *minus) Next(t time.Time) time.Time {
 	}
 }
 
+// maxIntersectHorizon bounds how far intersect.Next searches ahead by default,
+// so that it never spins forever on disjoint schedules. It spans
+// a leap day, e.g. for `0 0 0 29 2 *`.
+const maxIntersectHorizon = 5 * 366 * 24 * time.Hour
+
 // Intersect returns the intersection of left schedule and right schedule(l ∩ r).
+// The Next of the intersection returns 0(Time.IsZero()) if the two schedules
+// are exhausted or do not coincide within five years. So a zero time may only
+// mean the horizon is reached, e.g. Feb 29 on a Monday comes every 28 years,
+// use IntersectWithin for a longer horizon.
+//
+// If both are cron expressions, and one contains the other or they are
+// disjoint, the Next of the intersection is not bounded by the horizon.
 func Intersect(l, r Schedule) Schedule {
-	return &intersect{
-		l: l,
-		r: r,
+	return IntersectWithin(l, r, maxIntersectHorizon)
+}
+
+// IntersectWithin returns the intersection of left schedule and right
+// schedule(l ∩ r) like Intersect, whose Next searches up to the horizon ahead.
+func IntersectWithin(l, r Schedule, horizon time.Duration) Schedule {
+	is := &intersect{
+		l:       l,
+		r:       r,
+		horizon: horizon,
+	}
+	le, lok := l.(*cron.Expression)
+	re, rok := r.(*cron.Expression)
+	if lok && rok {
+		switch {
+		case le.Contains(re):
+			is.subset = r
+		case re.Contains(le):
+			is.subset = l
+		default:
+			is.disjoint = le.Disjoint(re)
+		}
 	}
+	return is
 }
 
 type intersect struct {
-	l Schedule
-	r Schedule
+	l        Schedule
+	r        Schedule
+	horizon  time.Duration
+	subset   Schedule // the operand contained by the other, if known
+	disjoint bool     // the operands never coincide
+}
+
+func (is *intersect) Op() string {
+	return "intersect"
+}
+
+func (is *intersect) Operands() (Schedule, Schedule) {
+	return is.l, is.r
+}
+
+func (is *intersect) Clone() Schedule {
+	return IntersectWithin(cloneSchedule(is.l), cloneSchedule(is.r), is.horizon)
 }
 
 func (is *intersect) Next(t time.Time) time.Time {
-	t1 := is.l.Next(t)
-	t2 := is.r.Next(t)
+	if is.subset != nil {
+		return is.subset.Next(t)
+	}
+	if is.disjoint {
+		return time.Time{}
+	}
+	return seekCoincide(is.l, is.r, is.l.Next(t), is.r.Next(t), t.Add(is.horizon))
+}
+
+// seekCoincide returns the first common time of the schedules l and r,
+// which fire next at t1 and t2. The lagging schedule is advanced straight to
+// the leading one, so a dense schedule meets a sparse one in a few probes.
+// It returns 0 if either schedule is exhausted, or reaches the end first.
+func seekCoincide(l, r Schedule, t1, t2, end time.Time) time.Time {
 	for {
-		if t1.IsZero() || t2.IsZero() {
-			return t1
+		if t1.IsZero() || t2.IsZero() || !t1.Before(end) || !t2.Before(end) {
+			return time.Time{}
 		}
 
 		if t1.Equal(t2) { // valid
@@ -116,9 +265,443 @@ func (is *intersect) Next(t time.Time) time.Time {
 		}
 
 		if t1.Before(t2) {
-			t1 = is.l.Next(t1)
+			t1 = seek(l, t1, t2)
 		} else {
-			t2 = is.r.Next(t2)
+			t2 = seek(r, t2, t1)
+		}
+	}
+}
+
+// seek advances the schedule s, which fires next at prev, to its first time
+// at or after t. A stateful schedule steps from prev instead, its times depend
+// on the previous ones, e.g. a Period.
+func seek(s Schedule, prev, t time.Time) time.Time {
+	if stateful(s) {
+		return s.Next(prev)
+	}
+	return s.Next(t.Add(-time.Nanosecond))
+}
+
+// stateful reports whether the times of s depend on its previous times.
+// A composite schedule is stateful only if either of its operands is.
+func stateful(s Schedule) bool {
+	if comp, ok := s.(CompositeSchedule); ok {
+		l, r := comp.Operands()
+		return stateful(l) || stateful(r)
+	}
+	_, ok := s.(StatefulSchedule)
+	return ok
+}
+
+// Coincide reports whether a and b have a common fire time after from and
+// before from+within, e.g. to check an intersection is not empty before
+// scheduling it. The schedules are advanced like the Next of Intersect,
+// bounded by the window. The schedules are not changed,
+// a StatefulSchedule is cloned before advancing.
+func Coincide(a, b Schedule, within time.Duration, from time.Time) bool {
+	a, b = cloneSchedule(a), cloneSchedule(b)
+	return !seekCoincide(a, b, a.Next(from), b.Next(from), from.Add(within)).IsZero()
+}
+
+// maxCoverageFires bounds the fires that Coverage iterates.
+const maxCoverageFires = 1 << 16
+
+// Coverage estimates the fraction of the window after from that s is active,
+// assuming each fire lasts the duration, e.g. 0.004 for 0.4% of the time.
+// The overlapping fires are counted once, and the fires beyond the first 65536
+// fires in the window are ignored. The schedule is not changed,
+// a StatefulSchedule is cloned before advancing.
+func Coverage(s Schedule, from time.Time, window, duration time.Duration) float64 {
+	if window <= 0 || duration <= 0 {
+		return 0
+	}
+
+	s = cloneSchedule(s)
+	end := from.Add(window)
+	var covered time.Duration
+	var coveredTo time.Time // the end of the covered time so far
+	next := s.Next(from)
+	for i := 0; i < maxCoverageFires && !next.IsZero() && next.Before(end); i++ {
+		start, stop := next, next.Add(duration)
+		if start.Before(coveredTo) {
+			start = coveredTo
+		}
+		if stop.After(end) {
+			stop = end
+		}
+		if stop.After(start) {
+			covered += stop.Sub(start)
+			coveredTo = stop
+		}
+		next = s.Next(next)
+	}
+	return float64(covered) / float64(window)
+}
+
+// OrElse returns the schedule that fires at the times of primary until
+// primary is exhausted, and then switches to fallback permanently.
+func OrElse(primary, fallback Schedule) Schedule {
+	return &orElse{primary: primary, fallback: fallback}
+}
+
+type orElse struct {
+	primary, fallback Schedule
+	switched          bool // primary is exhausted
+}
+
+func (oe *orElse) Op() string {
+	return "orElse"
+}
+
+func (oe *orElse) Operands() (Schedule, Schedule) {
+	return oe.primary, oe.fallback
+}
+
+func (oe *orElse) Clone() Schedule {
+	return &orElse{
+		primary:  cloneSchedule(oe.primary),
+		fallback: cloneSchedule(oe.fallback),
+		switched: oe.switched,
+	}
+}
+
+func (oe *orElse) Next(t time.Time) time.Time {
+	if !oe.switched {
+		if next := oe.primary.Next(t); !next.IsZero() {
+			return next
+		}
+		oe.switched = true
+	}
+	return oe.fallback.Next(t)
+}
+
+// When returns the schedule that has the same times as inner, but the fires
+// are suppressed while enabled returns false. The times of inner keep advancing,
+// the suppressed fires are skipped rather than delayed.
+//
+// The enabled func is called on the run loop of the Scheduler when a fire is due,
+// it must not block. The returned schedule must be the schedule of the job,
+// not an operand of a composite schedule.
+func When(inner Schedule, enabled func() bool) Schedule {
+	return &when{inner: inner, enabled: enabled}
+}
+
+type when struct {
+	inner   Schedule
+	enabled func() bool
+}
+
+func (ws *when) Clone() Schedule {
+	return &when{inner: cloneSchedule(ws.inner), enabled: ws.enabled}
+}
+
+func (ws *when) Next(t time.Time) time.Time {
+	return ws.inner.Next(t)
+}
+
+// In returns the schedule that evaluates the cron expression in loc,
+// regardless of the location of the Scheduler, e.g. to fire at 00:00 of loc.
+// The times are returned in loc. If loc is nil, UTC is used.
+func In(expr *cron.Expression, loc *time.Location) Schedule {
+	if loc == nil {
+		loc = time.UTC
+	}
+	return &inLocation{expr: expr, loc: loc}
+}
+
+// InUTC returns the schedule that evaluates the cron expression in UTC,
+// regardless of the location of the Scheduler.
+func InUTC(expr *cron.Expression) Schedule {
+	return In(expr, time.UTC)
+}
+
+type inLocation struct {
+	expr *cron.Expression
+	loc  *time.Location
+}
+
+func (il *inLocation) Next(t time.Time) time.Time {
+	return il.expr.Next(t.In(il.loc))
+}
+
+// NotBefore returns the schedule that has the times of inner at or after start,
+// e.g. to hold the jobs registered at startup until a launch time.
+// Unlike a window, it has no end.
+func NotBefore(inner Schedule, start time.Time) Schedule {
+	return &notBefore{inner: inner, start: start}
+}
+
+type notBefore struct {
+	inner Schedule
+	start time.Time
+}
+
+func (nb *notBefore) Clone() Schedule {
+	return &notBefore{inner: cloneSchedule(nb.inner), start: nb.start}
+}
+
+func (nb *notBefore) Next(t time.Time) time.Time {
+	if from := nb.start.Add(-time.Nanosecond); t.Before(from) {
+		t = from.In(t.Location()) // a time at start is included
+	}
+	return nb.inner.Next(t)
+}
+
+// ComplementDaily returns the schedule that fires at each step boundary of a day
+// when inner does not fire, e.g. the complement of an hourly cron with a 15m step
+// fires at :15, :30 and :45 of each hour. The boundaries are counted from the midnight
+// of each day. The schedule never fires if the step is less than 1s. If loc is nil,
+// the location of the time given to Next is used.
+//
+// A call of Next searches the boundaries within one day after the given time only,
+// so its cost is bounded by a day. If inner fires at each of them, Next returns
+// the last boundary searched as a pending time, which is not fired, and the search
+// continues from there when the scheduler calls Next again. So the returned schedule
+// must be the schedule of the job, not an operand of a composite schedule,
+// or the pending times fire.
+func ComplementDaily(inner Schedule, step time.Duration, loc *time.Location) Schedule {
+	if step < time.Second {
+		return emptySchedule{}
+	}
+	return &complementDaily{inner: inner, step: step, loc: loc}
+}
+
+type complementDaily struct {
+	inner   Schedule
+	step    time.Duration
+	loc     *time.Location
+	pending bool // the time returned last is where the search continues
+}
+
+func (cd *complementDaily) Clone() Schedule {
+	clone := *cd
+	clone.inner = cloneSchedule(cd.inner)
+	return &clone
+}
+
+func (cd *complementDaily) Next(t time.Time) time.Time {
+	lt := t
+	if cd.loc != nil {
+		lt = t.In(cd.loc)
+	}
+	y, m, d := lt.Date()
+	day := time.Date(y, m, d, 0, 0, 0, 0, lt.Location())
+	nextDay := day.AddDate(0, 0, 1)
+
+	b := day.Add((lt.Sub(day)/cd.step + 1) * cd.step)
+
+	cd.pending = false
+	last := b
+	for end := t.Add(24 * time.Hour); !b.After(end); b = b.Add(cd.step) {
+		if !b.Before(nextDay) { // restart the boundaries at the midnight
+			day, nextDay = nextDay, nextDay.AddDate(0, 0, 1)
+			b = day
+		}
+		if cd.free(b) {
+			return b
 		}
+		last = b
+	}
+	// inner fires at every boundary in a day, the search continues
+	// from the last boundary by the next call
+	cd.pending = true
+	return last
+}
+
+// free reports whether inner does not fire at the boundary b.
+func (cd *complementDaily) free(b time.Time) bool {
+	return !cloneSchedule(cd.inner).Next(b.Add(-time.Nanosecond)).Equal(b)
+}
+
+func (cd *complementDaily) suppressed() bool {
+	return cd.pending
+}
+
+// Dedup returns the schedule that has the times of inner, but skips the times
+// within tolerance after the time it returned last, so a burst of near-duplicate
+// times, e.g. of the union of the near-coincident schedules, fires only once.
+func Dedup(inner Schedule, tolerance time.Duration) Schedule {
+	return &dedup{inner: inner, tolerance: tolerance}
+}
+
+type dedup struct {
+	inner     Schedule
+	tolerance time.Duration
+	last      time.Time // the time returned last
+}
+
+func (ds *dedup) Clone() Schedule {
+	return &dedup{inner: cloneSchedule(ds.inner), tolerance: ds.tolerance, last: ds.last}
+}
+
+func (ds *dedup) Next(t time.Time) time.Time {
+	next := ds.inner.Next(t)
+	for !next.IsZero() && !ds.last.IsZero() && next.Sub(ds.last) <= ds.tolerance {
+		after := ds.inner.Next(next)
+		if !after.After(next) { // not advancing
+			break
+		}
+		next = after
+	}
+	if !next.IsZero() {
+		ds.last = next
 	}
+	return next
+}
+
+// suppressor is implemented by the schedules whose fires may be suppressed.
+type suppressor interface {
+	// suppressed called on the run loop when a fire of the schedule is due.
+	suppressed() bool
+}
+
+func (ws *when) suppressed() bool {
+	return !ws.enabled()
+}
+
+// suppressed reports whether the fire of the schedule is suppressed.
+func suppressed(s Schedule) bool {
+	ss, ok := s.(suppressor)
+	return ok && ss.suppressed()
+}
+
+// OnRisingEdge returns the schedule that polls cond every poll, and fires
+// only when cond turns true from false, once per rising edge. cond is
+// assumed false before the first poll.
+//
+// cond is called on the run loop of the Scheduler when a poll is due,
+// it must not block. The returned schedule must be the schedule of the job,
+// not an operand of a composite schedule. The schedule never fires if
+// poll is less than 1ms.
+func OnRisingEdge(poll time.Duration, cond func() bool) Schedule {
+	if poll < minInterval {
+		return emptySchedule{}
+	}
+	return &risingEdge{poll: poll, cond: cond}
+}
+
+type risingEdge struct {
+	poll time.Duration
+	cond func() bool
+	last bool // the value of cond observed last
+}
+
+func (re *risingEdge) Clone() Schedule {
+	clone := *re
+	return &clone
+}
+
+func (re *risingEdge) Next(t time.Time) time.Time {
+	return t.Add(re.poll)
+}
+
+func (re *risingEdge) suppressed() bool {
+	v := re.cond()
+	rising := v && !re.last
+	re.last = v
+	return !rising
+}
+
+// Simplify folds the trivial composite schedules, Minus(x, x) never fires,
+// Intersect(x, x) and Union(x, x) are x. The operands are the same if they are
+// equal cron expressions or the same comparable values. The operands that never
+// fire are folded as well, e.g. OrElse(x, never) is x. The other schedules
+// are returned unchanged.
+func Simplify(s Schedule) Schedule {
+	comp, ok := s.(CompositeSchedule)
+	if !ok {
+		return s
+	}
+
+	l, r := comp.Operands()
+	l, r = Simplify(l), Simplify(r)
+	_, lEmpty := l.(emptySchedule)
+	_, rEmpty := r.(emptySchedule)
+	same := sameSchedule(l, r)
+
+	switch comp.Op() {
+	case "union":
+		if same || rEmpty {
+			return l
+		}
+		if lEmpty {
+			return r
+		}
+		return Union(l, r)
+	case "minus":
+		if same || lEmpty {
+			return emptySchedule{}
+		}
+		if rEmpty {
+			return l
+		}
+		return Minus(l, r)
+	case "intersect":
+		if lEmpty || rEmpty {
+			return emptySchedule{}
+		}
+		if same {
+			return l
+		}
+		if is, ok := comp.(*intersect); ok {
+			return IntersectWithin(l, r, is.horizon)
+		}
+		return Intersect(l, r)
+	case "orElse":
+		if lEmpty {
+			return r
+		}
+		if rEmpty {
+			return l
+		}
+		return OrElse(l, r)
+	}
+	return s
+}
+
+// sameSchedule reports whether l and r are the same schedule.
+func sameSchedule(l, r Schedule) bool {
+	if le, ok := l.(*cron.Expression); ok {
+		re, ok := r.(*cron.Expression)
+		return ok && le.Equal(re)
+	}
+
+	t := reflect.TypeOf(l)
+	return t == reflect.TypeOf(r) && t.Comparable() && l == r
+}
+
+// cloneSchedule returns a copy of the schedule if it is stateful,
+// otherwise returns itself.
+func cloneSchedule(s Schedule) Schedule {
+	if ss, ok := s.(StatefulSchedule); ok {
+		return ss.Clone()
+	}
+	return s
+}
+
+// scheduleString describes s in the syntax of ParseSchedule.
+func scheduleString(s Schedule) string {
+	switch v := s.(type) {
+	case *cron.Expression:
+		return v.Normalized()
+	case *inLocation:
+		return "CRON_TZ=" + v.loc.String() + " " + v.expr.Normalized()
+	case *fractionalSeconds:
+		return v.spec
+	case CompositeSchedule:
+		l, r := v.Operands()
+		ls, rs := scheduleString(l), scheduleString(r)
+		if ls == "" || rs == "" {
+			return ""
+		}
+		return v.Op() + "(" + ls + ", " + rs + ")"
+	}
+	return ""
+}
+
+// emptySchedule is a schedule that never fires.
+type emptySchedule struct{}
+
+func (emptySchedule) Next(time.Time) time.Time {
+	return time.Time{}
 }
@@ -6,6 +6,7 @@ package scheduler
 
 import (
 	"fmt"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -90,6 +91,158 @@ func TestCompsite(t *testing.T) {
 	}
 }
 
+func TestIntersect_Disjoint(t *testing.T) {
+	from := time.Date(2020, 4, 25, 8, 30, 0, 0, time.UTC)
+
+	// never coincide, and never exhaust
+	even := ScheduleFunc(func(t time.Time) time.Time {
+		return t.Truncate(2 * time.Hour).Add(2 * time.Hour)
+	})
+	odd := ScheduleFunc(func(t time.Time) time.Time {
+		return t.Add(time.Hour).Truncate(2 * time.Hour).Add(time.Hour)
+	})
+	assert.True(t, Intersect(even, odd).Next(from).IsZero())
+
+	hour := cron.MustParse("0 0 * * * *")
+	halfHour := cron.MustParse("0 30 * * * *")
+	assert.True(t, Intersect(hour, halfHour).Next(from).IsZero())
+
+	// one of the schedules is exhausted
+	once := whSchedule{[]time.Time{from.Add(30 * time.Minute)}}
+	next := Intersect(hour, once).Next(from)
+	assert.Equal(t, from.Add(30*time.Minute), next)
+	assert.True(t, Intersect(hour, once).Next(next).IsZero())
+
+	// coincide on the first probe
+	assert.Equal(t, hour.Next(from), Intersect(hour, hour).Next(from))
+}
+
+func TestIntersect_DenseAndSparse(t *testing.T) {
+	from := time.Date(2020, 4, 25, 8, 30, 0, 0, time.UTC)
+	everySecond := cron.MustParse("* * * * * *")
+	yearly := cron.MustParse("@yearly")
+
+	want := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	assert.Equal(t, want, Intersect(everySecond, yearly).Next(from))
+	assert.Equal(t, want, Intersect(yearly, everySecond).Next(from))
+
+	leapDay := cron.MustParse("0 0 0 29 2 *")
+	assert.Equal(t, time.Date(2024, 2, 29, 0, 0, 0, 0, time.UTC),
+		Intersect(everySecond, leapDay).Next(time.Date(2020, 3, 1, 0, 0, 0, 0, time.UTC)))
+}
+
+func TestIntersect_LongPeriod(t *testing.T) {
+	from := time.Date(2020, 3, 1, 0, 0, 0, 0, time.UTC)
+	leapDay := cron.MustParse("0 0 0 29 2 ?")
+	monday := cron.MustParse("0 0 0 ? * MON")
+
+	// Feb 29 on a Monday repeats every 28 years, beyond the default horizon
+	assert.True(t, Intersect(leapDay, monday).Next(from).IsZero())
+	assert.Equal(t, time.Date(2044, 2, 29, 0, 0, 0, 0, time.UTC),
+		IntersectWithin(leapDay, monday, 30*366*24*time.Hour).Next(from))
+	assert.Equal(t, time.Date(2044, 2, 29, 0, 0, 0, 0, time.UTC),
+		Simplify(IntersectWithin(leapDay, monday, 30*366*24*time.Hour)).Next(from))
+
+	// a contained expression is not bounded by the horizon
+	everySecond := cron.MustParse("* * * * * *")
+	far := cron.MustParse("0 0 0 29 2 ? 2060")
+	want := time.Date(2060, 2, 29, 0, 0, 0, 0, time.UTC)
+	assert.Equal(t, want, Intersect(everySecond, far).Next(from))
+	assert.Equal(t, want, Intersect(far, everySecond).Next(from))
+
+	// disjoint expressions are not searched
+	assert.True(t, Intersect(leapDay, cron.MustParse("0 0 0 * 3 ?")).Next(from).IsZero())
+}
+
+func TestParseAny(t *testing.T) {
+	sched, err := ParseAny("0 9 * * MON-FRI | 0 12 * * SAT|0 0 1 1 *")
+	assert.NoError(t, err)
+
+	layout := "Mon 2006-01-02 15:04"
+	from := time.Date(2020, 12, 30, 10, 0, 0, 0, time.UTC) // Wednesday
+	want := []string{
+		"Thu 2020-12-31 09:00",
+		"Fri 2021-01-01 00:00",
+		"Fri 2021-01-01 09:00",
+		"Sat 2021-01-02 12:00",
+		"Mon 2021-01-04 09:00",
+	}
+	for _, w := range want {
+		from = sched.Next(from)
+		assert.Equal(t, w, from.Format(layout))
+	}
+
+	_, err = ParseAny("0 9 * * MON-FRI || 0 12 * * SAT")
+	assert.Error(t, err)
+	_, err = ParseAny("0 9 * * MON-FRI | ")
+	assert.Error(t, err)
+	_, err = ParseAny("0 9 * * MON-FRI | 0 12 * *")
+	assert.Error(t, err)
+
+	assert.True(t, UnionAll().Next(from).IsZero())
+}
+
+func describeSchedule(s Schedule) string {
+	switch v := s.(type) {
+	case CompositeSchedule:
+		l, r := v.Operands()
+		return v.Op() + "(" + describeSchedule(l) + ", " + describeSchedule(r) + ")"
+	case *cron.Expression:
+		return v.Normalized()
+	default:
+		return "?"
+	}
+}
+
+func TestCompositeSchedule(t *testing.T) {
+	a := cron.MustParse("0 9 * * MON-FRI")
+	b := cron.MustParse("0 9 1 1 *")
+	c := cron.MustParse("0 12 * * SAT")
+	d := whSchedule{}
+	comp := Union(Minus(a, b), Intersect(c, d))
+
+	assert.Equal(t, "union(minus(0 9 * * 1-5, 0 9 1 1 *), intersect(0 12 * * 6, ?))",
+		describeSchedule(comp))
+
+	op, ok := comp.(CompositeSchedule)
+	assert.True(t, ok)
+	assert.Equal(t, "union", op.Op())
+	l, _ := op.Operands()
+	_, r := l.(CompositeSchedule).Operands()
+	assert.True(t, b == r)
+}
+
+func TestSimplify(t *testing.T) {
+	a := cron.MustParse("0 9 * * MON-FRI")
+	a2 := cron.MustParse("0 9 * * 1-5")
+	b := cron.MustParse("0 12 * * SAT")
+	wh := whSchedule{}
+
+	assert.True(t, a == Simplify(Union(a, a2)))
+	assert.True(t, a == Simplify(Intersect(a, a2)))
+	assert.Equal(t, emptySchedule{}, Simplify(Minus(a, a2)))
+	assert.True(t, b == Simplify(Union(Minus(a, a2), b)))
+	assert.Equal(t, emptySchedule{}, Simplify(Intersect(b, Minus(a, a))))
+	assert.True(t, a == Simplify(Minus(a, Minus(b, b))))
+
+	// unknown and incomparable schedules pass through
+	assert.True(t, a == Simplify(a))
+	assert.Equal(t, "union(?, ?)", describeSchedule(Simplify(Union(wh, wh))))
+	assert.Equal(t, "union(0 9 * * 1-5, 0 12 * * 6)", describeSchedule(Simplify(Union(a, b))))
+
+	// the simplified schedules behave the same
+	from := time.Date(2020, 4, 25, 8, 30, 0, 0, time.UTC)
+	for _, comp := range []Schedule{Union(a, a2), Intersect(a, a2), Union(a, b)} {
+		simplified := Simplify(comp)
+		t1, t2 := from, from
+		for i := 0; i < 5; i++ {
+			t1, t2 = comp.Next(t1), simplified.Next(t2)
+			assert.Equal(t, t1, t2)
+		}
+	}
+	assert.True(t, Simplify(Minus(a, a2)).Next(from).IsZero())
+}
+
 type whSchedule struct {
 	times []time.Time
 }
@@ -139,3 +292,299 @@ func ExampleUnion() {
 	// 2020-05-12 08:30:00
 	// 2020-05-13 08:30:00
 }
+
+func TestWhen(t *testing.T) {
+	option, tick := WithManualTick()
+	s := New(option)
+	defer s.Shutdown()
+
+	var enabled, counter int32 = 1, 0
+	mjob, _ := s.PostFunc(When(ScheduleFunc(func(t time.Time) time.Time {
+		return t.Add(time.Hour)
+	}), func() bool {
+		return atomic.LoadInt32(&enabled) != 0
+	}), func() {
+		atomic.AddInt32(&counter, 1)
+	}, nil)
+	start := mjob.NextTime()
+
+	tick(start)
+	assert.EqualValues(t, 1, atomic.LoadInt32(&counter))
+
+	atomic.StoreInt32(&enabled, 0)
+	tick(start.Add(time.Hour))
+	tick(start.Add(2 * time.Hour))
+	assert.EqualValues(t, 1, atomic.LoadInt32(&counter))
+	assert.Equal(t, start.Add(3*time.Hour), mjob.NextTime()) // keeps advancing
+
+	atomic.StoreInt32(&enabled, 1)
+	tick(start.Add(3 * time.Hour))
+	assert.EqualValues(t, 2, atomic.LoadInt32(&counter))
+
+	// the same times as inner
+	inner := cron.MustParse("0 0 9 * * MON-FRI")
+	sched := When(inner, func() bool { return false })
+	from := time.Now()
+	assert.Equal(t, inner.Next(from), sched.Next(from))
+}
+
+func TestOrElse(t *testing.T) {
+	primary := cron.MustParse("0 0 12 1 * * 2024")
+	fallback := cron.MustParse("0 0 9 * * *")
+	sched := OrElse(primary, fallback)
+
+	next := time.Date(2024, 10, 15, 0, 0, 0, 0, time.UTC)
+	var nexts []time.Time
+	for i := 0; i < 4; i++ {
+		next = sched.Next(next)
+		nexts = append(nexts, next)
+	}
+	assert.Equal(t, []time.Time{
+		time.Date(2024, 11, 1, 12, 0, 0, 0, time.UTC),
+		time.Date(2024, 12, 1, 12, 0, 0, 0, time.UTC),
+		time.Date(2024, 12, 2, 9, 0, 0, 0, time.UTC), // switched
+		time.Date(2024, 12, 3, 9, 0, 0, 0, time.UTC),
+	}, nexts)
+
+	// the switch is permanent, even if primary has later times
+	from := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	assert.Equal(t, time.Date(2024, 1, 1, 9, 0, 0, 0, time.UTC), sched.Next(from))
+	clone := sched.(StatefulSchedule).Clone()
+	assert.Equal(t, time.Date(2024, 1, 1, 9, 0, 0, 0, time.UTC), clone.Next(from))
+	assert.Equal(t, time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC), OrElse(primary, fallback).Next(from))
+
+	// the year 2025
+	sched = OrElse(primary, fallback)
+	assert.Equal(t, time.Date(2025, 1, 1, 9, 0, 0, 0, time.UTC), sched.Next(time.Date(2024, 12, 31, 23, 0, 0, 0, time.UTC)))
+
+	assert.Equal(t, fallback, Simplify(OrElse(emptySchedule{}, fallback)))
+	assert.Equal(t, primary, Simplify(OrElse(primary, emptySchedule{})))
+}
+
+func TestCoincide(t *testing.T) {
+	from := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	week := 7 * 24 * time.Hour
+	tests := []struct {
+		a, b     string
+		within   time.Duration
+		coincide bool
+	}{
+		{"0 9 * * MON-FRI", "0 9 * * FRI", week, true},
+		{"0 9 * * MON-FRI", "0 9 * * SAT,SUN", 52 * week, false},
+		{"0 */15 * * * *", "0 */20 * * * *", time.Hour + time.Second, true},
+		{"0 */15 * * * *", "0 */20 * * * *", time.Hour, false}, // the end is excluded
+		{"0 0 13 * *", "0 0 * * FRI", 52 * week, true},         // OR of the day fields
+		{"0 0 0 13 * FRI#2", "0 0 0 1 * *", 52 * week, false},
+		{"0 0 0 1 1 *", "0 0 0 1 * *", 53 * week, true},
+		{"0 0 0 1 1 *", "0 0 0 1 * *", 52 * week, false}, // beyond the window
+		{"0 0 9 * * *", "0 0 10 * * *", 52 * week, false},
+		{"* * * * * *", "0 0 0 1 1 *", 366 * 24 * time.Hour, true}, // dense and sparse
+	}
+
+	for _, test := range tests {
+		a, b := cron.MustParse(test.a), cron.MustParse(test.b)
+		assert.Equal(t, test.coincide, Coincide(a, b, test.within, from), test.a+" & "+test.b)
+		assert.Equal(t, test.coincide, Coincide(b, a, test.within, from), test.b+" & "+test.a)
+	}
+
+	// the dense schedule is advanced to the sparse one
+	from = time.Date(2021, 6, 1, 0, 0, 0, 0, time.UTC)
+	dense := Union(cron.MustParse("* * * * * *"), cron.MustParse("0 0 0 * * *"))
+	assert.True(t, Coincide(dense, cron.MustParse("0 0 0 1 1 *"), 366*24*time.Hour, from))
+
+	// stateful schedules are not changed
+	period := &periodSchedule{initialDelay: time.Minute, period: time.Minute}
+	assert.True(t, Coincide(period, cron.MustParse("0 */5 * * * *"), time.Hour, from))
+	assert.False(t, period.called)
+}
+
+func TestDedup(t *testing.T) {
+	halfSecondLate := ScheduleFunc(func(t time.Time) time.Time {
+		next := t.Truncate(time.Minute).Add(500 * time.Millisecond)
+		for !next.After(t) {
+			next = next.Add(time.Minute)
+		}
+		return next
+	})
+	burst := Union(cron.MustParse("0 * * * * *"), halfSecondLate)
+	from := time.Date(2020, 1, 1, 11, 59, 30, 0, time.UTC)
+
+	next := burst.Next(from)
+	assert.Equal(t, from.Add(30*time.Second), next)
+	assert.Equal(t, from.Add(30*time.Second+500*time.Millisecond), burst.Next(next))
+
+	schedule := Dedup(burst, time.Second)
+	next = from
+	for i := 0; i < 3; i++ {
+		next = schedule.Next(next)
+		assert.Equal(t, from.Add(time.Duration(i)*time.Minute+30*time.Second), next, "fire %d", i)
+	}
+
+	// a clone keeps the last time
+	clone := cloneSchedule(schedule)
+	assert.Equal(t, schedule.Next(next), clone.Next(next))
+}
+
+func TestCoverage(t *testing.T) {
+	from := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	everyMinute := cron.MustParse("0 * * * * *")
+	assert.InDelta(t, 0.5, Coverage(everyMinute, from, time.Hour, 30*time.Second), 0.01)
+	assert.InDelta(t, 0.5, Coverage(everyMinute, from, 24*time.Hour, 30*time.Second), 0.001)
+
+	// the overlapping fires are counted once
+	assert.InDelta(t, 1, Coverage(everyMinute, from, time.Hour, 2*time.Minute), 0.02)
+
+	daily := cron.MustParse("0 0 12 * * *")
+	assert.InDelta(t, float64(time.Minute)/float64(24*time.Hour),
+		Coverage(daily, from, 30*24*time.Hour, time.Minute), 1e-9)
+
+	assert.Equal(t, 0.0, Coverage(everyMinute, from, 0, time.Second))
+	assert.Equal(t, 0.0, Coverage(UnionAll(), from, time.Hour, time.Second))
+}
+
+func TestInUTC(t *testing.T) {
+	ist := time.FixedZone("IST", 5*3600+1800)
+	s := NewManual(WithLocation(ist))
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, ist)
+	s.Advance(start)
+
+	var fired int
+	mjob, err := s.PostFunc(InUTC(cron.MustParse("0 0 0 * * *")), func() { fired++ }, "reset")
+	assert.NoError(t, err)
+	assert.Equal(t, "2020-01-01 05:30 IST", mjob.NextTime().Format("2006-01-02 15:04 MST"))
+	assert.Equal(t, "CRON_TZ=UTC 0 0 0 * * *", mjob.ScheduleString())
+
+	s.Advance(time.Date(2020, 1, 1, 5, 29, 0, 0, ist))
+	assert.Equal(t, 0, fired)
+	s.Advance(time.Date(2020, 1, 1, 5, 30, 0, 0, ist))
+	assert.Equal(t, 1, fired)
+	assert.Equal(t, "2020-01-02 05:30 IST", mjob.NextTime().Format("2006-01-02 15:04 MST"))
+
+	// the local cron fires at the local midnight
+	local, _ := s.CronFunc("0 0 0 * * *", func() {}, "local")
+	assert.Equal(t, "2020-01-02 00:00 IST", local.NextTime().Format("2006-01-02 15:04 MST"))
+
+	ny, _ := time.LoadLocation("America/New_York")
+	next := In(cron.MustParse("0 0 9 * * *"), ny).Next(start)
+	assert.Equal(t, "2020-01-01 09:00 EST", next.Format("2006-01-02 15:04 MST"))
+}
+
+func TestComplementDaily(t *testing.T) {
+	hourly := cron.MustParse("0 0 * * * *")
+	idle := ComplementDaily(hourly, 15*time.Minute, time.UTC)
+
+	layout := "2006-01-02 15:04"
+	from, _ := time.Parse(layout, "2020-01-01 22:50")
+	var times []string
+	for next := from; len(times) < 6; {
+		next = idle.Next(next)
+		times = append(times, next.Format(layout))
+	}
+	assert.Equal(t, []string{
+		"2020-01-01 23:15", "2020-01-01 23:30", "2020-01-01 23:45",
+		"2020-01-02 00:15", "2020-01-02 00:30", "2020-01-02 00:45",
+	}, times)
+
+	// the boundaries restart at the midnight
+	sevenHours := ComplementDaily(cron.MustParse("0 0 0 * * *"), 7*time.Hour, time.UTC)
+	from, _ = time.Parse(layout, "2020-01-01 15:00")
+	next := sevenHours.Next(from)
+	assert.Equal(t, "2020-01-01 21:00", next.Format(layout))
+	assert.Equal(t, "2020-01-02 07:00", sevenHours.Next(next).Format(layout))
+
+	// the following days are searched by the next calls, from the pending midnights
+	weekdays := ComplementDaily(cron.MustParse("0 */15 * * * MON-SAT"), 15*time.Minute, time.UTC)
+	times = nil
+	for next = from; len(times) < 4; {
+		next = weekdays.Next(next)
+		times = append(times, fmt.Sprintf("%s %v", next.Format(layout), suppressed(weekdays)))
+	}
+	assert.Equal(t, []string{
+		"2020-01-02 15:00 true", "2020-01-03 15:00 true",
+		"2020-01-04 15:00 true", "2020-01-05 00:00 false",
+	}, times)
+
+	// never fires if inner fires at every boundary, a call searches a day at most
+	var calls int
+	always := ComplementDaily(ScheduleFunc(func(t time.Time) time.Time {
+		calls++
+		return t.Truncate(time.Second).Add(time.Second)
+	}), time.Second, time.UTC)
+	next = from
+	for i := 0; i < 3; i++ {
+		calls = 0
+		next = always.Next(next)
+		assert.Equal(t, from.AddDate(0, 0, i+1), next)
+		assert.True(t, suppressed(always))
+		assert.True(t, calls <= 24*60*60+1, "calls: %d", calls)
+	}
+	assert.True(t, ComplementDaily(hourly, time.Millisecond, nil).Next(from).IsZero())
+}
+
+func TestComplementDaily_Scheduler(t *testing.T) {
+	s := NewManual(WithLocation(time.UTC))
+	s.Advance(time.Date(2020, 1, 1, 15, 0, 0, 0, time.UTC)) // Wednesday
+
+	var fired []time.Time
+	weekdays := ComplementDaily(cron.MustParse("0 */15 * * * MON-SAT"), 15*time.Minute, time.UTC)
+	mjob, err := s.PostFunc(weekdays, func() {}, "sunday")
+	assert.NoError(t, err)
+	for at := mjob.NextTime(); at.Before(time.Date(2020, 1, 5, 0, 20, 0, 0, time.UTC)); at = mjob.NextTime() {
+		s.Advance(at)
+		if mjob.RunCount() > len(fired) {
+			fired = append(fired, at)
+		}
+	}
+	assert.Equal(t, 1, s.Count())
+	assert.Equal(t, []time.Time{
+		time.Date(2020, 1, 5, 0, 0, 0, 0, time.UTC),
+		time.Date(2020, 1, 5, 0, 15, 0, 0, time.UTC),
+	}, fired)
+}
+
+func TestOnRisingEdge(t *testing.T) {
+	s := NewManual(WithLocation(time.UTC))
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	s.Advance(start)
+
+	values := []bool{false, false, true, true, true, false, true, false, false, true}
+	var polls, fires int
+	_, err := s.PostFunc(OnRisingEdge(time.Second, func() bool {
+		v := values[polls]
+		polls++
+		return v
+	}), func() { fires++ }, "edge")
+	assert.NoError(t, err)
+
+	wants := []int{0, 0, 1, 1, 1, 1, 2, 2, 2, 3}
+	for i := range values {
+		s.Advance(start.Add(time.Duration(i+1) * time.Second))
+		assert.Equal(t, i+1, polls)
+		assert.Equal(t, wants[i], fires, "poll %d", i)
+	}
+
+	assert.True(t, OnRisingEdge(0, func() bool { return true }).Next(start).IsZero())
+}
+
+func TestNotBefore(t *testing.T) {
+	s := NewManual(WithLocation(time.UTC))
+	now := time.Date(2020, 1, 1, 10, 0, 0, 0, time.UTC)
+	s.Advance(now)
+
+	launch := now.Add(3 * 24 * time.Hour) // 2020-01-04 10:00
+	var fires int
+	mjob, err := s.PostFunc(NotBefore(cron.MustParse("0 0 9 * * *"), launch), func() { fires++ }, "daily")
+	assert.NoError(t, err)
+	assert.Equal(t, "2020-01-05 09:00", mjob.NextTime().Format("2006-01-02 15:04"))
+
+	s.Advance(launch)
+	assert.Equal(t, 0, fires)
+	s.Advance(launch.Add(2 * 24 * time.Hour))
+	assert.Equal(t, 2, fires)
+
+	// a time at start is included
+	atStart := NotBefore(cron.MustParse("0 0 9 * * *"), time.Date(2020, 1, 4, 9, 0, 0, 0, time.UTC))
+	assert.Equal(t, "2020-01-04 09:00", atStart.Next(now).Format("2006-01-02 15:04"))
+	later := time.Date(2020, 2, 1, 12, 0, 0, 0, time.UTC)
+	assert.Equal(t, "2020-02-02 09:00", atStart.Next(later).Format("2006-01-02 15:04"))
+}
@@ -31,9 +31,14 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"io"
 	"os"
+	"sort"
+	"strconv"
+	"strings"
 	"sync"
 	"sync/atomic"
+	"text/tabwriter"
 	"time"
 
 	"github.com/cnotch/scheduler/cron"
@@ -41,25 +46,79 @@ import (
 
 const (
 	minInterval = time.Millisecond // minimum trigger interval
+	maxMissed   = 1024             // maximum fire times returned by MissedSince
+)
+
+// expiredChan is always ready to receive, it replaces the timer
+// when the jobs have expired.
+var expiredChan = func() <-chan time.Time {
+	c := make(chan time.Time)
+	close(c)
+	return c
+}()
+
+// jobSeq is the registration sequence of the jobs.
+var jobSeq uint64
+
+// The reasons passed to the dead-letter hook, see WithDeadLetter.
+const (
+	DeadLetterPanic   = "schedule panicked"
+	DeadLetterStalled = "schedule next time not advancing"
 )
 
 // PanicHandler is to handle panic caused by an asynchronous job.
+// It is also called when the run loop of the Scheduler panics,
+// job is the job being rescheduled at that time, or nil if none.
 type PanicHandler func(job *ManagedJob, r interface{})
 
+// Locker is to acquire a lock for a job before it runs, e.g. a distributed lock
+// that makes only one of the instances in a cluster run the job.
+type Locker interface {
+	// TryLock tries to acquire the lock for the job with the tag without blocking.
+	// If the lock is acquired, it returns true and the func to release the lock.
+	TryLock(tag interface{}) (bool, func())
+}
+
 // A Scheduler maintains a registry of Jobs.
 // Once registered, the Scheduler is responsible for executing Jobs
 // when their scheduled time arrives.
 type Scheduler struct {
 	count        int64
+	idle         int32 // atomic, non-zero when the queue is empty
+	fires        int64 // the fires dispatched
+	skipped      int64 // the fires of the exclusive jobs skipped for the overlap
+	latencySum   int64 // the sum of the fire latencies
+	maxLatency   int64
+	draining     int32
 	wg           *sync.WaitGroup
 	add          chan *ManagedJob
 	remove       chan *ManagedJob
 	snapshot     chan chan []*ManagedJob
+	commands     chan func(jobs *jobQueue)
 	panicHandler atomic.Value
 	loc          *time.Location
 	ctx          context.Context
 	cancel       context.CancelFunc
 	terminated   bool
+	exited       chan struct{} // closed when the run loop exits
+	shutdownOnce sync.Once
+	current      *ManagedJob     // the job being rescheduled by the run loop
+	tick         chan manualTick // manual ticks, disable the timer if not nil
+	ticked       *sync.WaitGroup // the jobs dispatched by the current manual tick
+	rate         rateLimit
+	locker       Locker
+	tagLimit     *tagLimit    // the concurrent runs per tag
+	inline       bool         // run the jobs on the run loop
+	executor     func(func()) // runs the jobs instead of the go statement, see WithExecutor
+	manual       *manualQueue // the jobs without the run loop, see NewManual
+	onAdd        func(*ManagedJob)
+	onRemove     func(*ManagedJob)
+	clock        Clock
+	lastWall     time.Time     // the wall clock time last tracked by the run loop
+	lastMono     time.Duration // the monotonic time last tracked by the run loop
+	deadLetter   func(*ManagedJob, string)
+	minWake      time.Duration // the floor of the timer sleeps, see WithMinWake
+	lastWake     int64         // atomic, the duration the run loop last slept
 }
 
 // New returns a new Scheduler instance.
@@ -69,7 +128,11 @@ func New(options ...Option) *Scheduler {
 		add:      make(chan *ManagedJob),
 		remove:   make(chan *ManagedJob),
 		snapshot: make(chan chan []*ManagedJob),
+		commands: make(chan func(jobs *jobQueue)),
+		exited:   make(chan struct{}),
+		idle:     1,
 		loc:      time.Local,
+		clock:    systemClock{},
 	}
 
 	for _, option := range options {
@@ -104,6 +167,22 @@ func (s *Scheduler) After(delay time.Duration, job Job, tag interface{}) (*Manag
 	return s.Post(&afterSchedule{delay: delay}, job, tag)
 }
 
+// AtFunc posts the function f to the Scheduler.
+// The function f will execute at the specified time only once,
+// and then remove from the Scheduler. If the time is past,
+// f executes as soon as possible.
+func (s *Scheduler) AtFunc(t time.Time, f func(), tag interface{}) (*ManagedJob, error) {
+	return s.At(t, JobFunc(f), tag)
+}
+
+// At posts the job to the Scheduler.
+// The job will execute at the specified time only once,
+// and then remove from the Scheduler. If the time is past,
+// the job executes as soon as possible.
+func (s *Scheduler) At(t time.Time, job Job, tag interface{}) (*ManagedJob, error) {
+	return s.Post(&atSchedule{at: t}, job, tag)
+}
+
 // PeriodFunc posts the function f to the Scheduler.
 // The function f will execute the first time at the specified delay,
 // followed by a fixed period. If the execution time of f exceeds
@@ -116,95 +195,429 @@ func (s *Scheduler) PeriodFunc(initialDelay, period time.Duration, f func(), tag
 // The job will execute the first time at the specified delay,
 // followed by a fixed period. If the execution time of job exceeds
 // the period, there will be multiple instances of job running at the same time.
+//
+// The period must not be less than 1ms, except that a zero period means
+// the job executes only once like After. The fires missed because of a short
+// period or a busy scheduler are dispatched as soon as possible, without waiting
+// for a timer.
 func (s *Scheduler) Period(initialDelay, period time.Duration, job Job, tag interface{}) (*ManagedJob, error) {
-	if period < time.Millisecond {
+	schedule, err := newPeriodSchedule(initialDelay, period)
+	if err != nil {
+		return nil, err
+	}
+	return s.Post(schedule, job, tag)
+}
+
+func newPeriodSchedule(initialDelay, period time.Duration) (Schedule, error) {
+	if period == 0 { // one-shot
+		return &afterSchedule{delay: initialDelay}, nil
+	}
+	if period < minInterval {
 		return nil, errors.New("preiod must not be less than 1ms")
 	}
-	return s.Post(&periodSchedule{initialDelay: initialDelay, period: period}, job, tag)
+	return &periodSchedule{initialDelay: initialDelay, period: period}, nil
+}
+
+// RepeatFunc posts the function f to the Scheduler.
+// The function f will execute the first time at the specified delay,
+// followed by a fixed gap, for the given times.
+func (s *Scheduler) RepeatFunc(initialDelay, gap time.Duration, times int, f func(), tag interface{}) (*ManagedJob, error) {
+	return s.Repeat(initialDelay, gap, times, JobFunc(f), tag)
+}
+
+// Repeat posts the job to the Scheduler.
+// The job will execute the first time at the specified delay,
+// followed by a fixed gap, for the given times,
+// and then remove from the Scheduler.
+func (s *Scheduler) Repeat(initialDelay, gap time.Duration, times int, job Job, tag interface{}) (*ManagedJob, error) {
+	if times <= 0 {
+		return nil, errors.New("times must be greater than 0")
+	}
+	if gap < minInterval {
+		return nil, errors.New("gap must not be less than 1ms")
+	}
+	return s.Post(&repeatSchedule{initialDelay: initialDelay, gap: gap, times: times}, job, tag)
 }
 
 // CronFunc posts the function f to the Scheduler, and associate the given cron expression with it.
+// In addition to the cron expression, `@every <duration>` is accepted, e.g. `@every 30s` or `@every 1d`,
+// optionally aligned to the clock, e.g. `@every 15m@:00` fires at :00, :15, :30 and :45.
+// A fractional step is accepted in the seconds field, e.g. `*/0.5 * * * * *` fires every 500ms.
 func (s *Scheduler) CronFunc(cronExpr string, f func(), tag interface{}) (*ManagedJob, error) {
 	return s.Cron(cronExpr, JobFunc(f), tag)
 }
 
 // Cron posts the job to the Scheduler, and associate the given cron expression with it.
+// In addition to the cron expression, `@every <duration>` is accepted, e.g. `@every 30s` or `@every 1d`,
+// optionally aligned to the clock, e.g. `@every 15m@:00` fires at :00, :15, :30 and :45.
+// A fractional step is accepted in the seconds field, e.g. `*/0.5 * * * * *` fires every 500ms.
 func (s *Scheduler) Cron(cronExpr string, job Job, tag interface{}) (*ManagedJob, error) {
-	cexp, err := cron.Parse(cronExpr)
+	schedule, err := parseCron(cronExpr)
 	if err != nil {
 		return nil, err
 	}
-	return s.Post(cexp, job, tag)
+	return s.Post(schedule, job, tag)
+}
+
+// RelativeTo posts the job to the Scheduler, the job fires at the offset
+// from each next time of the job a, e.g. 5 minutes after a. A negative offset
+// fires before a. Once a is cancelled or exhausted, the job doesn't fire and
+// is removed from the Scheduler. a must be posted to the Scheduler.
+func (s *Scheduler) RelativeTo(a *ManagedJob, offset time.Duration, job Job, tag interface{}) (*ManagedJob, error) {
+	if a == nil || a.owner != s {
+		return nil, errors.New("job is not posted to the scheduler")
+	}
+	return s.Post(&relativeSchedule{base: a, offset: offset}, job, tag)
+}
+
+// ScheduleUntilSuccess posts the job to the Scheduler, and associate the given schedule with it.
+// The job runs at the times of the schedule while it returns an error or panics,
+// and it's cancelled once it returns nil.
+func (s *Scheduler) ScheduleUntilSuccess(schedule Schedule, job FallibleJob, tag interface{}) (*ManagedJob, error) {
+	us := &untilSuccess{job: job}
+	return s.Post(schedule, us, tag, jobOptionFunc(func(j *ManagedJob) {
+		us.mjob = j // before the job is added
+	}))
 }
 
 // PostFunc posts the function f to the Scheduler, and associate the given schedule with it.
-func (s *Scheduler) PostFunc(schedule Schedule, f func(), tag interface{}) (*ManagedJob, error) {
-	return s.Post(schedule, JobFunc(f), tag)
+func (s *Scheduler) PostFunc(schedule Schedule, f func(), tag interface{}, options ...JobOption) (*ManagedJob, error) {
+	return s.Post(schedule, JobFunc(f), tag, options...)
 }
 
 // Post posts the job to the Scheduler, and associate the given schedule with it.
-func (s *Scheduler) Post(schedule Schedule, job Job, tag interface{}) (mjob *ManagedJob, err error) {
-	defer func() { // after terminated, add throw panic
-		if r := recover(); r != nil {
-			err = errors.New("scheduler is terminated")
-		}
-	}()
+// The options configure the job, e.g. WithJobValue.
+func (s *Scheduler) Post(schedule Schedule, job Job, tag interface{}, options ...JobOption) (mjob *ManagedJob, err error) {
+	if s.manual == nil && s.ctx.Err() != nil {
+		return nil, errors.New("scheduler is terminated")
+	}
+	if atomic.LoadInt32(&s.draining) != 0 {
+		return nil, errors.New("scheduler draining")
+	}
+
+	j, err := s.newJob(schedule, job, tag, options)
+	if err != nil {
+		return nil, err
+	}
+	if s.manual != nil {
+		s.execManual(func(jobs *jobQueue) {
+			s.addJob(j, jobs)
+		})
+		return j, nil
+	}
+	select {
+	case s.add <- j:
+		return j, nil
+	case <-s.ctx.Done():
+		return nil, errors.New("scheduler is terminated")
+	}
+}
 
+// newJob creates the job of the schedule, which is not added to the queue yet.
+func (s *Scheduler) newJob(schedule Schedule, job Job, tag interface{}, options []JobOption) (*ManagedJob, error) {
 	postTime := s.now()
 	next := schedule.Next(postTime)
+	computeTime := time.Since(postTime)
 	if next.IsZero() {
 		return nil, errors.New("schedule is empty, never a scheduled time to arrive")
 	}
 
 	j := &ManagedJob{
-		tag:      tag,
-		schelule: schedule,
-		job:      job,
-		remove:   s.remove,
-		postTime: postTime,
-		next:     next,
+		tag:         tag,
+		schelule:    schedule,
+		job:         job,
+		remove:      s.remove,
+		owner:       s,
+		postTime:    postTime,
+		next:        next,
+		computeTime: int64(computeTime),
+		seq:         atomic.AddUint64(&jobSeq, 1),
+		done:        make(chan struct{}),
+		dead:        make(chan struct{}),
 	}
 	j.nextTime.set(j.next)
+	for _, option := range options {
+		option.applyJob(j)
+	}
+	return j, nil
+}
+
+// Replace replaces all the jobs of the Scheduler with the jobs posted by register,
+// in a single operation of the run loop. If register returns an error or panics,
+// the jobs posted by register are discarded and the existing jobs are kept.
+//
+// The register is called on the run loop with a Staging, which collects
+// the jobs instead of the Scheduler. It must not call the methods of the Scheduler.
+// The register takes a Staging rather than a *Scheduler, because a Scheduler
+// passed in would offer every method, e.g. Count or Cancel, and those wait for
+// the run loop, which is busy calling the register, so they would deadlock.
+// The Staging offers only Post and PostFunc, which are safe there.
+func (s *Scheduler) Replace(register func(*Staging) error) (err error) {
+	ok := s.exec(func(jobs *jobQueue) {
+		staging := &Staging{owner: s}
+		defer func() {
+			if r := recover(); r != nil {
+				err = fmt.Errorf("register panics: %v", r)
+			}
+			if err != nil { // rollback
+				for _, j := range staging.jobs {
+					j.setRemoved()
+				}
+			}
+		}()
+
+		if err = register(staging); err != nil {
+			return
+		}
 
-	s.add <- j
+		// commit
+		for jobs.Len() > 0 {
+			s.jobRemoved(heap.Pop(jobs).(*ManagedJob))
+		}
+		for _, j := range staging.jobs {
+			s.addJob(j, jobs)
+		}
+	})
+	if !ok {
+		return errors.New("scheduler is terminated")
+	}
+	return
+}
+
+// Staging collects the jobs posted in the register of Scheduler.Replace,
+// which replace the jobs of the Scheduler once the register succeeds.
+type Staging struct {
+	owner *Scheduler
+	jobs  []*ManagedJob
+}
+
+// PostFunc posts the function f to the Staging, and associate the given schedule with it.
+func (st *Staging) PostFunc(schedule Schedule, f func(), tag interface{}, options ...JobOption) (*ManagedJob, error) {
+	return st.Post(schedule, JobFunc(f), tag, options...)
+}
+
+// Post posts the job to the Staging, and associate the given schedule with it.
+// The options configure the job, e.g. WithJobValue.
+func (st *Staging) Post(schedule Schedule, job Job, tag interface{}, options ...JobOption) (*ManagedJob, error) {
+	j, err := st.owner.newJob(schedule, job, tag, options)
+	if err != nil {
+		return nil, err
+	}
+	st.jobs = append(st.jobs, j)
 	return j, nil
 }
 
-// Shutdown shutdowns scheduler.
+// Shutdown shutdowns scheduler. It's safe to call Shutdown multiple times
+// and from multiple goroutines, the posting after the shutdown returns an error.
+// It's a no-op for the Scheduler created by NewManual.
 func (s *Scheduler) Shutdown() {
-	s.cancel()
+	if s.manual != nil {
+		return
+	}
+	s.shutdownOnce.Do(s.cancel)
 }
 
 // ShutdownAndWait shutdowns scheduler and wait for all jobs to complete.
 func (s *Scheduler) ShutdownAndWait() {
-	s.cancel()
+	s.Shutdown()
 	s.wg.Wait()
 }
 
+// Drain stops the scheduler accepting new jobs, the posting returns an error,
+// while the existing jobs keep running until the scheduler shutdowns.
+func (s *Scheduler) Drain() {
+	atomic.StoreInt32(&s.draining, 1)
+}
+
+// Undrain resumes the scheduler accepting new jobs.
+func (s *Scheduler) Undrain() {
+	atomic.StoreInt32(&s.draining, 0)
+}
+
 // Terminated determines that the scheduler has terminated
 func (s *Scheduler) Terminated() bool {
-	return s.terminated
+	select {
+	case <-s.exited: // nil for the Scheduler created by NewManual
+		return true
+	default:
+		return false
+	}
 }
 
 // Jobs returns the scheduled jobs.
 func (s *Scheduler) Jobs() (jobs []*ManagedJob) {
-	defer func() {
-		if r := recover(); r != nil {
-			jobs = nil // when s.snapshot closed
-		}
-	}()
+	if s.manual != nil {
+		s.execManual(func(queue *jobQueue) {
+			jobs = append([]*ManagedJob(nil), *queue...)
+		})
+		return
+	}
 	replyChan := make(chan []*ManagedJob, 1)
-	s.snapshot <- replyChan
-	jobs = <-replyChan
+	select {
+	case s.snapshot <- replyChan:
+		return <-replyChan
+	case <-s.ctx.Done():
+		return nil
+	}
+}
+
+// Dump writes a human-readable table of the jobs to w, sorted by the next time,
+// for diagnostics and bug reports. The states of the jobs are taken from
+// a consistent snapshot of the run loop.
+func (s *Scheduler) Dump(w io.Writer) {
+	var rows [][]interface{}
+	s.exec(func(jobs *jobQueue) {
+		queue := append(jobQueue(nil), *jobs...)
+		sort.Slice(queue, func(i, j int) bool { return queue[i].next.Before(queue[j].next) })
+		for _, j := range queue {
+			schedule := j.ScheduleString()
+			if schedule == "" {
+				schedule = fmt.Sprintf("%T", j.schelule)
+			}
+			rows = append(rows, []interface{}{j.tag, formatDumpTime(j.next.In(s.loc)),
+				formatDumpTime(j.PrevTime()), j.RunCount(), j.Enabled(), schedule})
+		}
+	})
+
+	fmt.Fprintf(w, "scheduler: %d jobs, now %s\n", len(rows), formatDumpTime(s.now()))
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "TAG\tNEXT\tPREV\tRUNS\tENABLED\tSCHEDULE")
+	for _, row := range rows {
+		fmt.Fprintf(tw, "%v\t%s\t%s\t%d\t%t\t%s\n", row...)
+	}
+	tw.Flush()
+}
+
+func formatDumpTime(t time.Time) string {
+	if t.IsZero() {
+		return "-"
+	}
+	return t.Format(time.RFC3339)
+}
+
+// JobsWithLabel returns the scheduled jobs labeled with key=val by WithLabels.
+func (s *Scheduler) JobsWithLabel(key, val string) (jobs []*ManagedJob) {
+	for _, j := range s.Jobs() {
+		if v, ok := j.labels[key]; ok && v == val {
+			jobs = append(jobs, j)
+		}
+	}
+	return
+}
+
+// RescheduleWhere replaces the schedule of each job matching pred with
+// transform(old) in a single operation of the run loop, e.g. to slow down
+// the low-priority jobs at once. The next time of the job is calculated by
+// the new schedule from now, and the job is removed if the new schedule
+// never fires. The job is unchanged if transform returns nil or the old schedule.
+// pred and transform are called on the run loop, they must not block or call
+// the methods of the Scheduler.
+func (s *Scheduler) RescheduleWhere(pred func(*ManagedJob) bool, transform func(Schedule) Schedule) {
+	s.exec(func(jobs *jobQueue) {
+		var matched []*ManagedJob
+		for _, j := range *jobs {
+			if pred(j) {
+				matched = append(matched, j)
+			}
+		}
+
+		now := s.now()
+		for _, j := range matched { // the rescheduling reorders the queue
+			schedule := transform(j.schelule)
+			if schedule == nil || sameSchedule(schedule, j.schelule) {
+				continue // unchanged
+			}
+			j.mu.Lock()
+			j.schelule = schedule
+			j.mu.Unlock()
+			next := schedule.Next(now)
+			if next.IsZero() {
+				s.removeJob(j, jobs)
+				continue
+			}
+			j.next = next
+			j.nextTime.set(next)
+			heap.Fix(jobs, j.index)
+		}
+	})
+}
+
+// DueCount returns the count of jobs due at or before the specified time.
+func (s *Scheduler) DueCount(at time.Time) (count int) {
+	s.exec(func(jobs *jobQueue) {
+		count = jobs.countDue(at, 0)
+	})
 	return
 }
 
+// MissedSince returns the fire times of the schedule after lastKnown and
+// at or before now, e.g. the fires missed while the service was down.
+// At most 1024 earliest times are returned. The schedule is not changed,
+// a StatefulSchedule is cloned before calculating the times.
+func (s *Scheduler) MissedSince(schedule Schedule, lastKnown time.Time) []time.Time {
+	now := s.now()
+	schedule = cloneSchedule(schedule)
+
+	var missed []time.Time
+	next := schedule.Next(lastKnown.In(s.loc))
+	for len(missed) < maxMissed && !next.IsZero() && !next.After(now) {
+		missed = append(missed, next)
+		next = schedule.Next(next)
+	}
+	return missed
+}
+
+// Stats is the statistics of the fires of the Scheduler.
+type Stats struct {
+	Fires      int64         // the count of the fires
+	Skipped    int64         // the count of the fires skipped for overlapping, see Exclusive
+	MaxLatency time.Duration // the max delay of the fires from their scheduled times
+	AvgLatency time.Duration // the average delay of the fires from their scheduled times
+}
+
+// Stats returns the statistics of the fires, it helps to detect
+// an overloaded scheduler.
+func (s *Scheduler) Stats() Stats {
+	stats := Stats{
+		Fires:      atomic.LoadInt64(&s.fires),
+		Skipped:    atomic.LoadInt64(&s.skipped),
+		MaxLatency: time.Duration(atomic.LoadInt64(&s.maxLatency)),
+	}
+	if stats.Fires > 0 {
+		stats.AvgLatency = time.Duration(atomic.LoadInt64(&s.latencySum) / stats.Fires)
+	}
+	return stats
+}
+
+// LastWakeInterval returns the duration the run loop last slept before
+// dispatching the expired jobs, 0 if the jobs were already due.
+func (s *Scheduler) LastWakeInterval() time.Duration {
+	return time.Duration(atomic.LoadInt64(&s.lastWake))
+}
+
 // Count returns jobs count.
 func (s *Scheduler) Count() int {
 	l := atomic.LoadInt64(&s.count)
 	return int(l)
 }
 
+// IsIdle reports whether the queue of the Scheduler is empty, so the run loop
+// sits in the idle timer rather than timing a job. The runs in progress are
+// not counted.
+func (s *Scheduler) IsIdle() bool {
+	return atomic.LoadInt32(&s.idle) != 0
+}
+
+// publishCount publishes the count of the jobs in the queue for Count and IsIdle.
+func (s *Scheduler) publishCount(count int) {
+	var idle int32
+	if count == 0 {
+		idle = 1
+	}
+	atomic.StoreInt64(&s.count, int64(count))
+	atomic.StoreInt32(&s.idle, idle)
+}
+
 // Location returns the time zone location of the scheduler.
 func (s *Scheduler) Location() *time.Location {
 	return s.loc
@@ -218,46 +631,141 @@ func (s *Scheduler) SetPanicHandler(panicHandler PanicHandler) {
 	s.panicHandler.Store(panicHandler)
 }
 
+// the delays before the run loop is restarted after a panic, doubled
+// by each restart and reset after the loop runs for maxRestartDelay.
+const (
+	minRestartDelay = time.Millisecond
+	maxRestartDelay = time.Second
+)
+
 func (s *Scheduler) run() {
 	defer s.wg.Done()
 
 	jobs := make(jobQueue, 0, 16)
+	var delay time.Duration
 	for {
-		atomic.StoreInt64(&s.count, int64(len(jobs)))
+		start := time.Now()
+		if s.loop(&jobs) {
+			return
+		}
+
+		// the loop was interrupted by an unexpected panic, restart it,
+		// backing off if it panics again soon, e.g. in the clock
+		if time.Since(start) >= maxRestartDelay {
+			delay = 0
+		}
+		if delay == 0 {
+			delay = minRestartDelay
+			continue
+		}
+		select {
+		case <-s.ctx.Done():
+			s.exit(&jobs)
+			return
+		case <-time.After(delay):
+		}
+		if delay *= 2; delay > maxRestartDelay {
+			delay = maxRestartDelay
+		}
+	}
+}
+
+// loop runs the scheduling loop until the scheduler exits.
+// It returns false if the loop panics, the job in its pass through
+// the loop at that time is removed, and the other jobs are preserved.
+func (s *Scheduler) loop(jobs *jobQueue) (exited bool) {
+	defer func() {
+		if r := recover(); r != nil {
+			j := s.current
+			s.current = nil
+			if j != nil {
+				s.dropPanicked(j, jobs)
+			}
+			panicHandler := s.panicHandler.Load().(PanicHandler)
+			panicHandler(j, r)
+		}
+	}()
+
+	for {
+		s.publishCount(len(*jobs))
+		if s.tick == nil {
+			s.trackClock(jobs)
+		}
 
 		d := time.Duration(100000 * time.Hour) // if there are no jobs
-		if len(jobs) > 0 {
-			d = jobs[0].next.Sub(s.now())
-			if d < 0 {
-				d = 0
+		if len(*jobs) > 0 && s.tick == nil {
+			d = s.rate.delay((*jobs)[0].next).Sub(s.now())
+			if d > 0 && d < s.minWake {
+				d = s.minWake // batch the jobs due within the min wake
 			}
 		}
-		timer := time.NewTimer(d)
+
+		// fast path, the expired jobs are dispatched without timer
+		expired := expiredChan
+		var timer *time.Timer
+		sleepStart := s.clock.Monotonic()
+		if d > 0 {
+			timer = time.NewTimer(d)
+			expired = timer.C
+		}
 
 		select {
 		case <-s.ctx.Done(): // exit Scheduler
-			timer.Stop()
-			s.internalClose()
-			return
+			stopTimer(timer)
+			s.exit(jobs)
+			return true
 
-		case now := <-timer.C:
-			now = now.In(s.loc)
-			s.runExpiredJobs(now, &jobs)
+		case <-expired:
+			atomic.StoreInt64(&s.lastWake, int64(s.clock.Monotonic()-sleepStart))
+			s.trackClock(jobs)
+			s.runExpiredJobs(s.now(), jobs)
+
+		case tick := <-s.tick:
+			s.manualTick(tick, jobs)
 
 		case newJ := <-s.add:
-			timer.Stop()
-			heap.Push(&jobs, newJ)
+			if s.tick == nil {
+				s.trackClock(jobs) // before the job posted with the stepped clock
+			}
+			s.addJob(newJ, jobs)
 
 		case removeJ := <-s.remove:
-			timer.Stop()
-			s.removeJob(removeJ, &jobs)
+			s.removeJob(removeJ, jobs)
+
+		case cmd := <-s.commands:
+			cmd(jobs)
 
 		case replyChan := <-s.snapshot:
-			timer.Stop()
-			snapshotJobs := make([]*ManagedJob, len(jobs))
-			copy(snapshotJobs, jobs)
+			snapshotJobs := make([]*ManagedJob, len(*jobs))
+			copy(snapshotJobs, *jobs)
 			replyChan <- snapshotJobs
 		}
+		stopTimer(timer)
+	}
+}
+
+// exit closes the scheduler on the run loop, the jobs in the queue are removed.
+func (s *Scheduler) exit(jobs *jobQueue) {
+	s.internalClose()
+	for _, j := range *jobs {
+		j.setRemoved()
+	}
+}
+
+// dropPanicked removes the job whose pass panicked, and dead letters it.
+// A panic in the hooks is dropped, so it does not escape the watchdog.
+func (s *Scheduler) dropPanicked(j *ManagedJob, jobs *jobQueue) {
+	defer func() {
+		recover()
+	}()
+	if s.removeJob(j, jobs) {
+		s.deadLettered(j, DeadLetterPanic)
+	}
+}
+
+func stopTimer(timer *time.Timer) {
+	if timer != nil {
+		timer.Stop()
 	}
 }
 
@@ -268,60 +776,470 @@ func (s *Scheduler) runExpiredJobs(now time.Time, jobs *jobQueue) {
 			break
 		}
 
-		s.wg.Add(1)
-		go s.safeRun(j)
+		// the job is removed if its pass panics, e.g. in the predicate of When
+		s.current = j
+		if j.Enabled() && !suppressed(j.schelule) && !s.skipOverlap(j) {
+			if !s.rate.take(now) {
+				s.current = nil
+				break // hold the remaining jobs until the next window
+			}
+			s.recordLatency(j, now.Sub(j.next))
+			s.dispatch(j)
+		}
 
+		start := time.Now()
 		next := j.schelule.Next(j.next)
+		atomic.AddInt64(&j.computeTime, int64(time.Since(start)))
 		if next.IsZero() {
 			heap.Pop(jobs)
+			s.jobRemoved(j)
+		} else if !next.After(j.next) { // would fire endlessly
+			heap.Pop(jobs)
+			s.jobRemoved(j)
+			s.deadLettered(j, DeadLetterStalled)
 		} else {
 			jobs.updateNext(j, next)
 		}
+		s.current = nil
+	}
+}
+
+// exec runs the command on the run loop, and waits for it to complete.
+// It returns false if the scheduler is terminated.
+func (s *Scheduler) exec(cmd func(jobs *jobQueue)) bool {
+	if s.manual != nil {
+		s.execManual(cmd)
+		return true
+	}
+	if s.ctx.Err() != nil { // don't race with the run loop exiting
+		return false
+	}
+
+	done := make(chan struct{})
+	select {
+	case <-s.ctx.Done():
+		return false
+	case s.commands <- func(jobs *jobQueue) {
+		defer close(done)
+		cmd(jobs)
+	}:
 	}
+	<-done
+	return true
 }
 
-func (s *Scheduler) safeRun(j *ManagedJob) {
+func (s *Scheduler) manualTick(tick manualTick, jobs *jobQueue) {
+	ticked := &sync.WaitGroup{}
+	s.ticked = ticked
 	defer func() {
+		s.ticked = nil
+		tick.reply <- ticked
+	}()
+	s.runExpiredJobs(tick.now.In(s.loc), jobs)
+}
+
+// skipOverlap reports whether the fire of an exclusive job is skipped
+// because its prior run is still active, and counts the skipped fire.
+func (s *Scheduler) skipOverlap(j *ManagedJob) bool {
+	if !j.exclusive {
+		return false
+	}
+	j.mu.Lock()
+	running := j.running
+	j.mu.Unlock()
+	if running == 0 {
+		return false
+	}
+	atomic.AddInt64(&j.skipped, 1)
+	atomic.AddInt64(&s.skipped, 1)
+	return true
+}
+
+// TotalFires returns the count of the fires dispatched since the Scheduler
+// started, including the runs that panicked.
+func (s *Scheduler) TotalFires() int64 {
+	return atomic.LoadInt64(&s.fires)
+}
+
+func (s *Scheduler) recordLatency(j *ManagedJob, latency time.Duration) {
+	atomic.StoreInt64(&j.latency, int64(latency))
+	atomic.AddInt64(&s.latencySum, int64(latency))
+	atomic.AddInt64(&s.fires, 1)
+	if int64(latency) > atomic.LoadInt64(&s.maxLatency) {
+		atomic.StoreInt64(&s.maxLatency, int64(latency))
+	}
+}
+
+func (s *Scheduler) dispatch(j *ManagedJob) {
+	s.wg.Add(1)
+	j.runStarted()
+	if s.manual != nil { // run by Advance later
+		at := j.next
+		s.manual.pending = append(s.manual.pending, func() {
+			s.safeRun(j, at)
+		})
+		return
+	}
+	if s.inline {
+		s.safeRun(j, j.next)
+		return
+	}
+	at := j.next
+	if s.ticked == nil {
+		s.spawn(func() {
+			s.safeRun(j, at)
+		}, func() {
+			j.runCompleted(false)
+			s.wg.Done()
+		})
+		return
+	}
+
+	ticked := s.ticked
+	ticked.Add(1)
+	s.spawn(func() {
+		defer ticked.Done()
+		s.safeRun(j, at)
+	}, func() {
+		j.runCompleted(false)
 		s.wg.Done()
+		ticked.Done()
+	})
+}
+
+// spawn runs the work on a new goroutine, or by the executor if configured.
+// If the executor panics, the work is abandoned unless it has started, and
+// the panic goes on to the run loop, which removes the job being dispatched.
+func (s *Scheduler) spawn(work func(), abandon func()) {
+	if s.executor == nil {
+		go work()
+		return
+	}
+
+	const started, abandoned = 1, 2
+	var state int32
+	defer func() {
+		if r := recover(); r != nil {
+			if atomic.CompareAndSwapInt32(&state, 0, abandoned) {
+				abandon()
+			}
+			panic(r)
+		}
+	}()
+	s.executor(func() {
+		if atomic.CompareAndSwapInt32(&state, 0, started) {
+			work()
+		}
+	})
+}
+
+// safeRun runs the job as if it were the time at.
+func (s *Scheduler) safeRun(j *ManagedJob, at time.Time) {
+	skipped := false
+	defer func() {
+		defer s.wg.Done()
+		defer func() { j.runCompleted(!skipped) }()
 		if r := recover(); r != nil {
 			panicHandler := s.panicHandler.Load().(PanicHandler)
 			panicHandler(j, r)
 		}
 	}()
-	j.job.Run()
+
+	if s.locker != nil {
+		ok, unlock := s.locker.TryLock(j.tag)
+		if !ok {
+			skipped = true
+			return // skip the run
+		}
+		defer unlock()
+	}
+	if s.tagLimit != nil {
+		if !s.tagLimit.acquire(j.tag) {
+			skipped = true
+			return // skip the run
+		}
+		defer s.tagLimit.release(j.tag)
+	}
+	atomic.StoreInt32(&j.hasRun, 1)
+	j.run(at)
 }
 
-func (s *Scheduler) removeJob(removeJ *ManagedJob, jobs *jobQueue) {
+func (s *Scheduler) removeJob(removeJ *ManagedJob, jobs *jobQueue) bool {
 	if removeJ.index < 0 || removeJ.index >= len(*jobs) {
-		return
+		return false
 	}
 
 	if removeJ == (*jobs)[removeJ.index] {
 		heap.Remove(jobs, removeJ.index)
+		s.jobRemoved(removeJ)
+		return true
+	}
+	return false
+}
+
+// removeOK removes the job on the run loop, and reports whether
+// the job was in the queue.
+func (s *Scheduler) removeOK(j *ManagedJob) (ok bool) {
+	s.exec(func(jobs *jobQueue) {
+		ok = s.removeJob(j, jobs)
+	})
+	return
+}
+
+func (s *Scheduler) addJob(j *ManagedJob, jobs *jobQueue) {
+	heap.Push(jobs, j)
+	if s.onAdd != nil {
+		s.onAdd(j)
+	}
+}
+
+// resumeJob enables the job in the queue, and restarts the interval
+// of an interval job from now, see ManagedJob.Resume.
+func (s *Scheduler) resumeJob(j *ManagedJob, jobs *jobQueue) {
+	wasEnabled := j.Enabled()
+	j.SetEnabled(true)
+	if wasEnabled || j.phased || j.prevTime.get().IsZero() {
+		return // not paused, phased or not fired yet
+	}
+
+	var interval time.Duration
+	switch schedule := j.schelule.(type) {
+	case *periodSchedule:
+		interval = schedule.period
+	case *repeatSchedule:
+		interval = schedule.gap
+	default:
+		return // the cron jobs keep their phase
+	}
+	next := s.now().Add(interval)
+	if !next.Equal(j.next) {
+		j.next = next
+		j.nextTime.set(next)
+		heap.Fix(jobs, j.index)
+	}
+}
+
+// deadLettered is called after the job is removed for a failure.
+func (s *Scheduler) deadLettered(j *ManagedJob, reason string) {
+	if s.deadLetter != nil {
+		s.deadLetter(j, reason)
+	}
+}
+
+// jobRemoved is called after the job is removed from the queue.
+func (s *Scheduler) jobRemoved(j *ManagedJob) {
+	j.setRemoved()
+	if s.onRemove != nil {
+		s.onRemove(j)
 	}
 }
 
 func (s *Scheduler) internalClose() {
 	s.terminated = true
-	close(s.add)
-	close(s.remove)
-	close(s.snapshot)
-	atomic.StoreInt64(&s.count, 0)
+	s.publishCount(0)
+	close(s.exited)
 }
 
 func (s *Scheduler) now() time.Time {
-	return time.Now().In(s.loc)
+	if s.manual != nil {
+		return s.manual.now.get().In(s.loc)
+	}
+	return s.clock.Now().In(s.loc)
 }
 
 func defaultPanicHandle(job *ManagedJob, r interface{}) {
+	if job == nil {
+		fmt.Fprintf(os.Stderr, "Scheduler: %+v\n", r)
+		return
+	}
 	fmt.Fprintf(os.Stderr, "Tag: %+v\n - %+v\n", job.tag, r)
 }
 
+const everyPrefix = "@every "
+
+// parseCron parses the cron expression or `@every <duration>`.
+func parseCron(spec string) (Schedule, error) {
+	spec = strings.TrimSpace(spec)
+	if !strings.HasPrefix(spec, everyPrefix) {
+		if fs, ok, err := parseFractionalSeconds(spec); ok {
+			return fs, err
+		}
+		cexp, err := cron.Parse(spec)
+		if err != nil {
+			return nil, err
+		}
+		return cexp, nil
+	}
+
+	every := strings.TrimSpace(spec[len(everyPrefix):])
+	var alignment string
+	idx := strings.IndexByte(every, '@')
+	if idx != -1 {
+		every, alignment = every[:idx], every[idx+1:]
+	}
+	period, err := parseEveryDuration(every)
+	if err != nil {
+		return nil, fmt.Errorf("invalid duration of @every: '%s'", every)
+	}
+	if period < minInterval {
+		return nil, errors.New("duration of @every must not be less than 1ms")
+	}
+	if idx == -1 {
+		return &periodSchedule{initialDelay: period, period: period}, nil
+	}
+
+	unit, offset, ok := parseAlignment(alignment)
+	if !ok {
+		return nil, fmt.Errorf("invalid alignment of @every: '%s'", alignment)
+	}
+	if unit%period != 0 {
+		return nil, fmt.Errorf("duration of @every must divide %s evenly when aligned", unit)
+	}
+	return &alignedSchedule{unit: unit, offset: offset % period, period: period}, nil
+}
+
+// parseFractionalSeconds parses the cron expression with a fractional step
+// in the seconds field, e.g. `*/0.5 * * * * *` fires every 500ms.
+// ok is false if the seconds field isn't a fractional step.
+func parseFractionalSeconds(spec string) (schedule Schedule, ok bool, err error) {
+	fields := strings.Fields(spec)
+	i := 0
+	if len(fields) > 0 && (strings.HasPrefix(fields[0], "TZ=") || strings.HasPrefix(fields[0], "CRON_TZ=")) {
+		i++ // the location of the expression
+	}
+	if len(fields)-i < 6 || !strings.HasPrefix(fields[i], "*/") ||
+		strings.IndexByte(fields[i], '.') == -1 {
+		return nil, false, nil
+	}
+
+	seconds, err := strconv.ParseFloat(fields[i][2:], 64)
+	step := time.Duration(seconds * float64(time.Second)).Round(time.Millisecond)
+	if err != nil || step < minInterval || step >= time.Minute {
+		return nil, true, fmt.Errorf("syntax error in second field: '%s'", fields[i])
+	}
+
+	fields[i] = "0" // the minutes matched by the other fields
+	expr, err := cron.Parse(strings.Join(fields, " "))
+	if err != nil {
+		return nil, true, err
+	}
+	return &fractionalSeconds{spec: spec, minutes: expr, step: step}, true, nil
+}
+
+// fractionalSeconds fires at the fractional steps from the start of
+// each minute matched by the minutes expression.
+type fractionalSeconds struct {
+	spec    string
+	minutes *cron.Expression
+	step    time.Duration
+}
+
+func (fs *fractionalSeconds) Next(t time.Time) time.Time {
+	minute := time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), 0, 0, t.Location())
+	if fs.minutes.Next(minute.Add(-time.Nanosecond)).Equal(minute) {
+		next := minute.Add((t.Sub(minute)/fs.step + 1) * fs.step)
+		if next.Sub(minute) < time.Minute {
+			return next
+		}
+	}
+	return fs.minutes.Next(minute)
+}
+
+// parseEveryDuration parses the duration of @every like time.ParseDuration,
+// and accepts the leading integral days and weeks, e.g. `1d`, `2w` or `1d12h`.
+// A day is exactly 24h and a week is 7 days, regardless of the calendar,
+// e.g. the daylight saving time changes.
+func parseEveryDuration(s string) (time.Duration, error) {
+	var total time.Duration
+	for {
+		i := strings.IndexAny(s, "dw")
+		if i == -1 {
+			break
+		}
+		n, err := strconv.Atoi(s[:i])
+		if err != nil || n < 0 {
+			return 0, fmt.Errorf("invalid days or weeks: '%s'", s[:i+1])
+		}
+		unit := 24 * time.Hour
+		if s[i] == 'w' {
+			unit *= 7
+		}
+		total += time.Duration(n) * unit
+		s = s[i+1:]
+	}
+	if s == "" {
+		return total, nil
+	}
+
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, err
+	}
+	return total + d, nil
+}
+
+// parseAlignment parses the alignment of @every, `:MM[:SS]` is the offset
+// in an hour and `HH:MM[:SS]` is the offset in a day.
+func parseAlignment(alignment string) (unit, offset time.Duration, ok bool) {
+	fields := strings.Split(alignment, ":")
+	unit = 24 * time.Hour
+	if fields[0] == "" { // in an hour
+		unit = time.Hour
+		fields = fields[1:]
+	}
+	if len(fields) < 1 || len(fields) > 3 || unit == time.Hour && len(fields) > 2 ||
+		unit != time.Hour && len(fields) < 2 {
+		return 0, 0, false
+	}
+
+	units := []time.Duration{time.Hour, time.Minute, time.Second}
+	if unit == time.Hour {
+		units = units[1:]
+	}
+	for i, field := range fields {
+		n, err := strconv.Atoi(field)
+		if err != nil || n < 0 || time.Duration(n)*units[i] >= unit || i > 0 && n > 59 {
+			return 0, 0, false
+		}
+		offset += time.Duration(n) * units[i]
+	}
+	return unit, offset, true
+}
+
+// alignedSchedule fires every period, aligned to the offset in each unit
+// of the local clock.
+type alignedSchedule struct {
+	unit   time.Duration // an hour or a day
+	offset time.Duration // the offset in the period
+	period time.Duration
+}
+
+func (as *alignedSchedule) Next(t time.Time) time.Time {
+	y, m, d := t.Date()
+	hour := t.Hour()
+	if as.unit != time.Hour {
+		hour = 0
+	}
+	base := time.Date(y, m, d, hour, 0, 0, 0, t.Location()).Add(as.offset)
+
+	n := t.Sub(base) / as.period
+	next := base.Add(n * as.period)
+	for !next.After(t) {
+		next = next.Add(as.period)
+	}
+	return next
+}
+
 type afterSchedule struct {
 	called bool
 	delay  time.Duration
 }
 
+func (at *afterSchedule) Clone() Schedule {
+	clone := *at
+	return &clone
+}
+
 func (at *afterSchedule) Next(t time.Time) time.Time {
 	if at.called {
 		return time.Time{}
@@ -331,11 +1249,62 @@ func (at *afterSchedule) Next(t time.Time) time.Time {
 	return t.Add(at.delay)
 }
 
+// relativeSchedule fires at the offset from the next times of the base job,
+// it's called on the run loop of the base job.
+type relativeSchedule struct {
+	base   *ManagedJob
+	offset time.Duration
+}
+
+func (rs *relativeSchedule) Next(t time.Time) time.Time {
+	next := rs.base.nextTime.get()
+	var schedule Schedule // the preview of the base, for a negative offset
+	for i := 0; i < maxClampProbes && !next.IsZero(); i++ {
+		if at := next.Add(rs.offset); at.After(t) {
+			return at.In(t.Location())
+		}
+		if schedule == nil {
+			schedule = cloneSchedule(rs.base.schelule)
+		}
+		next = schedule.Next(next)
+	}
+	return time.Time{}
+}
+
+// suppressed suppresses the pending fire once the base job is removed.
+func (rs *relativeSchedule) suppressed() bool {
+	return rs.base.nextTime.get().IsZero()
+}
+
+type atSchedule struct {
+	called bool
+	at     time.Time
+}
+
+func (at *atSchedule) Clone() Schedule {
+	clone := *at
+	return &clone
+}
+
+func (at *atSchedule) Next(t time.Time) time.Time {
+	if at.called {
+		return time.Time{}
+	}
+
+	at.called = true
+	return at.at
+}
+
 type periodSchedule struct {
 	called               bool
 	initialDelay, period time.Duration
 }
 
+func (pt *periodSchedule) Clone() Schedule {
+	clone := *pt
+	return &clone
+}
+
 func (pt *periodSchedule) Next(t time.Time) time.Time {
 	d := pt.initialDelay
 	if pt.called {
@@ -345,3 +1314,92 @@ func (pt *periodSchedule) Next(t time.Time) time.Time {
 	pt.called = true
 	return t.Add(d)
 }
+
+type repeatSchedule struct {
+	called            int
+	initialDelay, gap time.Duration
+	times             int
+}
+
+func (rt *repeatSchedule) Clone() Schedule {
+	clone := *rt
+	return &clone
+}
+
+func (rt *repeatSchedule) Next(t time.Time) time.Time {
+	if rt.called >= rt.times {
+		return time.Time{}
+	}
+
+	rt.called++
+	if rt.called == 1 {
+		return t.Add(rt.initialDelay)
+	}
+	return t.Add(rt.gap)
+}
+
+// tagLimit limits the concurrent runs of the jobs with the same tag.
+type tagLimit struct {
+	limit   func(tag interface{}) int // the max concurrent runs, 0 means no limit
+	mu      sync.Mutex
+	running map[interface{}]int // the runs in progress per tag
+}
+
+// acquire takes a run from the budget of the tag,
+// it returns false if the budget is exhausted.
+func (tl *tagLimit) acquire(tag interface{}) bool {
+	limit := tl.limit(tag)
+	tl.mu.Lock()
+	defer tl.mu.Unlock()
+	if limit > 0 && tl.running[tag] >= limit {
+		return false
+	}
+	tl.running[tag]++
+	return true
+}
+
+func (tl *tagLimit) release(tag interface{}) {
+	tl.mu.Lock()
+	defer tl.mu.Unlock()
+	if tl.running[tag]--; tl.running[tag] == 0 {
+		delete(tl.running, tag)
+	}
+}
+
+// rateLimit limits the fires per interval with fixed windows.
+type rateLimit struct {
+	limit int           // the max fires per window, 0 means no limit
+	per   time.Duration // the length of window
+	start time.Time     // the start of current window
+	fires int           // the fires in current window
+}
+
+// take takes a fire from the budget of the window at now,
+// it returns false if the budget is exhausted.
+func (rl *rateLimit) take(now time.Time) bool {
+	if rl.limit <= 0 {
+		return true
+	}
+
+	if now.Sub(rl.start) >= rl.per {
+		rl.start = now
+		rl.fires = 0
+	}
+	if rl.fires >= rl.limit {
+		return false
+	}
+	rl.fires++
+	return true
+}
+
+// delay returns the time the fire at next can be dispatched.
+func (rl *rateLimit) delay(next time.Time) time.Time {
+	if rl.limit <= 0 || rl.fires < rl.limit {
+		return next
+	}
+
+	if end := rl.start.Add(rl.per); next.Before(end) {
+		return end
+	}
+	return next
+}
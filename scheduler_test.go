@@ -5,12 +5,16 @@
 package scheduler
 
 import (
+	"context"
+	"errors"
 	"sort"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"testing"
 	"time"
 
+	"github.com/cnotch/scheduler/cron"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -257,3 +261,1576 @@ func TestManagedJob(t *testing.T) {
 		prev = next
 	}
 }
+
+type panicSchedule struct {
+	called bool
+}
+
+func (ps *panicSchedule) Next(t time.Time) time.Time {
+	if ps.called {
+		panic("bad schedule")
+	}
+	ps.called = true
+	return t.Add(10 * time.Millisecond)
+}
+
+func TestScheduler_RunLoopPanic(t *testing.T) {
+	var panicJob atomic.Value
+	s := New(WithPanicHandler(func(job *ManagedJob, r interface{}) {
+		if r == "bad schedule" {
+			panicJob.Store(job)
+		}
+	}))
+	defer s.Shutdown()
+
+	var counter int32
+	s.PeriodFunc(0, 10*time.Millisecond, func() {
+		atomic.AddInt32(&counter, 1)
+	}, "good")
+	bad, err := s.PostFunc(&panicSchedule{}, func() {}, "bad")
+	assert.Nil(t, err)
+
+	<-time.After(50 * time.Millisecond)
+	assert.Equal(t, bad, panicJob.Load())
+	assert.False(t, s.Terminated())
+	assert.Equal(t, 1, s.Count())
+
+	want := atomic.LoadInt32(&counter)
+	<-time.After(50 * time.Millisecond)
+	assert.True(t, atomic.LoadInt32(&counter) > want)
+}
+
+// panicClock is a clock whose Now panics.
+type panicClock struct{}
+
+func (panicClock) Now() time.Time {
+	panic("bad clock")
+}
+
+func (panicClock) Monotonic() time.Duration {
+	return systemClock{}.Monotonic()
+}
+
+func TestScheduler_RunLoopPanicBackoff(t *testing.T) {
+	var panics int32
+	s := New(WithClock(panicClock{}), WithPanicHandler(func(job *ManagedJob, r interface{}) {
+		atomic.AddInt32(&panics, 1)
+	}))
+
+	<-time.After(300 * time.Millisecond)
+	assert.True(t, atomic.LoadInt32(&panics) > 1)
+	assert.True(t, atomic.LoadInt32(&panics) < 20) // backed off, not spinning
+	assert.False(t, s.Terminated())
+
+	done := make(chan struct{})
+	go func() {
+		s.ShutdownAndWait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("ShutdownAndWait hangs in the backoff")
+	}
+	assert.True(t, s.Terminated())
+}
+
+func TestScheduler_RunLoopPanicInHook(t *testing.T) {
+	var panics int32
+	s := New(WithPanicHandler(func(job *ManagedJob, r interface{}) {
+		atomic.AddInt32(&panics, 1)
+	}), WithDeadLetter(func(job *ManagedJob, reason string) {
+		panic("bad dead letter")
+	}))
+	defer s.Shutdown()
+
+	var counter int32
+	s.PeriodFunc(0, 10*time.Millisecond, func() {
+		atomic.AddInt32(&counter, 1)
+	}, "good")
+	s.PostFunc(&panicSchedule{}, func() {}, "bad")
+
+	<-time.After(100 * time.Millisecond)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&panics))
+	assert.Equal(t, 1, s.Count())
+	assert.True(t, atomic.LoadInt32(&counter) > 3)
+}
+
+func TestManagedJob_Preview(t *testing.T) {
+	schd := New()
+	defer schd.Shutdown()
+
+	fired := make(chan time.Time, 5)
+	mjob, _ := schd.PeriodFunc(50*time.Millisecond, 50*time.Millisecond, func() {
+		fired <- time.Now()
+	}, "tag")
+	times := mjob.Preview(5)
+	assert.Equal(t, 5, len(times))
+	assert.Equal(t, times, mjob.Preview(5)) // preview does not change the job
+
+	for i, want := range times {
+		got := <-fired
+		assert.False(t, got.Before(want), "fire %d", i)
+		assert.True(t, got.Sub(want) < 40*time.Millisecond, "fire %d", i)
+	}
+	mjob.Cancel()
+
+	mjob, _ = schd.CronFunc("0 0 0 1 * ?", func() {}, "tag")
+	times = mjob.Preview(3)
+	assert.Equal(t, 3, len(times))
+	for _, tm := range times {
+		assert.Equal(t, 1, tm.Day())
+	}
+}
+
+func TestScheduler_ManualTick(t *testing.T) {
+	option, tick := WithManualTick()
+	s := New(option)
+	defer s.Shutdown()
+
+	var counter1, counter2 int32
+	mjob1, _ := s.PeriodFunc(time.Hour, time.Hour, func() {
+		atomic.AddInt32(&counter1, 1)
+	}, nil)
+	mjob2, _ := s.PeriodFunc(time.Millisecond, 90*time.Minute, func() {
+		atomic.AddInt32(&counter2, 1)
+	}, nil)
+	start := mjob1.NextTime().Add(-time.Hour)
+
+	<-time.After(10 * time.Millisecond) // the real timer never fires
+	assert.EqualValues(t, 0, atomic.LoadInt32(&counter2))
+
+	tick(mjob2.NextTime())
+	assert.EqualValues(t, 0, atomic.LoadInt32(&counter1))
+	assert.EqualValues(t, 1, atomic.LoadInt32(&counter2))
+
+	tick(start.Add(time.Hour - time.Nanosecond))
+	assert.EqualValues(t, 0, atomic.LoadInt32(&counter1))
+
+	tick(start.Add(time.Hour))
+	assert.EqualValues(t, 1, atomic.LoadInt32(&counter1))
+	assert.EqualValues(t, 1, atomic.LoadInt32(&counter2))
+
+	// catch up the missed fires
+	tick(start.Add(3 * time.Hour))
+	assert.EqualValues(t, 3, atomic.LoadInt32(&counter1))
+	assert.EqualValues(t, 2, atomic.LoadInt32(&counter2))
+
+	s.ShutdownAndWait()
+	tick(start.Add(4 * time.Hour)) // after shutdown
+	assert.EqualValues(t, 3, atomic.LoadInt32(&counter1))
+}
+
+func TestScheduler_ManualTickBeforeNew(t *testing.T) {
+	option, tick := WithManualTick()
+	assert.NotPanics(t, func() { tick(time.Now()) })
+
+	var counter int32
+	s := New(option)
+	defer s.Shutdown()
+	mjob, _ := s.PeriodFunc(time.Hour, time.Hour, func() { atomic.AddInt32(&counter, 1) }, "tag")
+	tick(mjob.NextTime())
+	assert.EqualValues(t, 1, atomic.LoadInt32(&counter))
+}
+
+func TestScheduler_DueCount(t *testing.T) {
+	s := New()
+	defer s.Shutdown()
+
+	now := time.Now()
+	for i := 1; i <= 5; i++ {
+		s.AfterFunc(time.Duration(i)*time.Hour, func() {}, i)
+		s.AfterFunc(time.Duration(i)*time.Hour+time.Minute, func() {}, i)
+	}
+
+	assert.Equal(t, 0, s.DueCount(now))
+	assert.Equal(t, 2, s.DueCount(now.Add(time.Hour+2*time.Minute)))
+	assert.Equal(t, 5, s.DueCount(now.Add(3*time.Hour+time.Second)))
+	assert.Equal(t, 10, s.DueCount(now.Add(6*time.Hour)))
+
+	s.ShutdownAndWait()
+	assert.Equal(t, 0, s.DueCount(now.Add(6*time.Hour)))
+}
+
+func TestScheduler_RateLimit(t *testing.T) {
+	s := New(WithRateLimit(2, 200*time.Millisecond))
+	defer s.Shutdown()
+
+	var counter int32
+	for i := 0; i < 7; i++ {
+		s.AfterFunc(10*time.Millisecond, func() {
+			atomic.AddInt32(&counter, 1)
+		}, i)
+	}
+
+	for _, want := range []int32{2, 4, 6, 7} {
+		<-time.After(100 * time.Millisecond)
+		assert.EqualValues(t, want, atomic.LoadInt32(&counter))
+		<-time.After(100 * time.Millisecond)
+	}
+	assert.Equal(t, 0, s.Count())
+}
+
+func TestScheduler_CronEvery(t *testing.T) {
+	s := New()
+	defer s.Shutdown()
+
+	fired := make(chan time.Time, 10)
+	start := time.Now()
+	mj, err := s.CronFunc("@every 50ms", func() {
+		fired <- time.Now()
+	}, nil)
+	assert.NoError(t, err)
+	defer mj.Cancel()
+
+	prev := start
+	for i := 0; i < 3; i++ {
+		select {
+		case <-time.After(time.Second):
+			t.Fatal("expected job runs")
+		case now := <-fired:
+			gap := now.Sub(prev)
+			assert.True(t, gap > 30*time.Millisecond && gap < 80*time.Millisecond, gap)
+			prev = now
+		}
+	}
+
+	for _, spec := range []string{"@every", "@every 1x", "@every 10us", "@every -1s"} {
+		_, err = s.CronFunc(spec, func() {}, nil)
+		assert.Error(t, err, spec)
+	}
+}
+
+func TestManagedJob_WaitNext(t *testing.T) {
+	s := New()
+	defer s.Shutdown()
+
+	var counter int32
+	mjob, _ := s.AfterFunc(time.Second, func() {
+		atomic.AddInt32(&counter, 1)
+	}, nil)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	assert.Equal(t, context.DeadlineExceeded, mjob.WaitNext(ctx))
+
+	// multiple waiters
+	errs := make(chan error, 3)
+	for i := 0; i < 3; i++ {
+		go func() {
+			ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+			defer cancel()
+			errs <- mjob.WaitNext(ctx)
+		}()
+	}
+	for i := 0; i < 3; i++ {
+		assert.NoError(t, <-errs)
+	}
+	assert.EqualValues(t, 1, atomic.LoadInt32(&counter))
+
+	// the job is terminated after runs once
+	assert.Error(t, mjob.WaitNext(context.Background()))
+
+	mjob, _ = s.AfterFunc(time.Hour, func() {}, nil)
+	go func() {
+		<-time.After(10 * time.Millisecond)
+		mjob.Cancel()
+	}()
+	assert.Error(t, mjob.WaitNext(context.Background()))
+
+	mjob, _ = s.AfterFunc(time.Hour, func() {}, nil)
+	go s.Shutdown()
+	assert.Error(t, mjob.WaitNext(context.Background()))
+}
+
+func TestScheduler_Drain(t *testing.T) {
+	s := New()
+	defer s.Shutdown()
+
+	var counter int32
+	s.PeriodFunc(0, 10*time.Millisecond, func() {
+		atomic.AddInt32(&counter, 1)
+	}, nil)
+
+	s.Drain()
+	_, err := s.AfterFunc(0, func() {}, nil)
+	if assert.Error(t, err) {
+		assert.Equal(t, "scheduler draining", err.Error())
+	}
+
+	want := atomic.LoadInt32(&counter)
+	<-time.After(50 * time.Millisecond)
+	assert.True(t, atomic.LoadInt32(&counter) > want)
+	assert.Equal(t, 1, s.Count())
+
+	s.Undrain()
+	_, err = s.AfterFunc(time.Hour, func() {}, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(s.Jobs()))
+}
+
+func TestScheduler_ShortPeriod(t *testing.T) {
+	s := New()
+	defer s.Shutdown()
+
+	var counter int32
+	s.PeriodFunc(0, time.Millisecond, func() {
+		atomic.AddInt32(&counter, 1)
+	}, nil)
+	<-time.After(100 * time.Millisecond)
+	assert.True(t, atomic.LoadInt32(&counter) > 50)
+
+	_, err := s.PeriodFunc(0, time.Millisecond-1, func() {}, nil)
+	assert.Error(t, err)
+	_, err = s.PeriodFunc(0, -time.Second, func() {}, nil)
+	assert.Error(t, err)
+}
+
+func TestScheduler_ZeroPeriod(t *testing.T) {
+	s := New()
+	defer s.Shutdown()
+
+	var counter int32
+	mjob, err := s.PeriodFunc(10*time.Millisecond, 0, func() {
+		atomic.AddInt32(&counter, 1)
+	}, nil)
+	assert.NoError(t, err)
+	<-time.After(100 * time.Millisecond)
+	assert.EqualValues(t, 1, atomic.LoadInt32(&counter))
+	assert.Equal(t, 1, mjob.RunCount())
+	assert.Equal(t, 0, len(s.Jobs()))
+}
+
+func TestScheduler_Repeat(t *testing.T) {
+	s := NewManual()
+	start := time.Now()
+	s.Advance(start)
+
+	var fires []time.Time
+	mjob, err := s.Repeat(time.Second, 10*time.Second, 5, JobFunc(func() {
+		fires = append(fires, s.now())
+	}), nil)
+	assert.NoError(t, err)
+	for i := 1; i <= 100; i++ {
+		s.Advance(start.Add(time.Duration(i) * time.Second))
+	}
+
+	assert.Equal(t, 5, len(fires))
+	assert.Equal(t, 5, mjob.RunCount())
+	for i, fire := range fires {
+		assert.Equal(t, time.Second+time.Duration(i)*10*time.Second, fire.Sub(start))
+	}
+	assert.Equal(t, 0, s.Count())
+
+	_, err = s.RepeatFunc(0, 10*time.Second, 0, func() {}, nil)
+	assert.Error(t, err)
+	_, err = s.RepeatFunc(0, 0, 5, func() {}, nil)
+	assert.Error(t, err)
+}
+
+func BenchmarkScheduler_Burst(b *testing.B) {
+	s := New()
+	defer s.Shutdown()
+
+	var wg sync.WaitGroup
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		wg.Add(1)
+		s.AfterFunc(0, wg.Done, nil)
+	}
+	wg.Wait()
+}
+
+func TestScheduler_Replace(t *testing.T) {
+	s := New()
+	defer s.Shutdown()
+
+	var oldCounter, newCounter int32
+	for i := 0; i < 3; i++ {
+		s.PeriodFunc(0, 10*time.Millisecond, func() {
+			atomic.AddInt32(&oldCounter, 1)
+		}, "old")
+	}
+	<-time.After(30 * time.Millisecond)
+
+	// rollback
+	err := s.Replace(func(staging *Staging) error {
+		staging.PostFunc(&periodSchedule{period: 10 * time.Millisecond}, func() {
+			atomic.AddInt32(&newCounter, 1)
+		}, "new")
+		return errors.New("bad config")
+	})
+	assert.Error(t, err)
+	assert.Equal(t, 3, len(s.Jobs()))
+
+	err = s.Replace(func(staging *Staging) error {
+		for i := 0; i < 2; i++ {
+			if _, err := staging.PostFunc(&periodSchedule{period: 10 * time.Millisecond}, func() {
+				atomic.AddInt32(&newCounter, 1)
+			}, "new"); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	assert.NoError(t, err)
+	jobs := s.Jobs()
+	assert.Equal(t, 2, len(jobs))
+	for _, job := range jobs {
+		assert.Equal(t, "new", job.Tag())
+	}
+
+	<-time.After(10 * time.Millisecond) // the running old jobs complete
+	want := atomic.LoadInt32(&oldCounter)
+	<-time.After(50 * time.Millisecond)
+	assert.Equal(t, want, atomic.LoadInt32(&oldCounter))
+	assert.True(t, atomic.LoadInt32(&newCounter) > 0)
+
+	jobs[0].Cancel()
+	assert.Equal(t, 1, len(s.Jobs()))
+
+	s.ShutdownAndWait()
+	assert.Error(t, s.Replace(func(*Staging) error { return nil }))
+}
+
+func TestScheduler_AddRemoveHooks(t *testing.T) {
+	var mu sync.Mutex
+	var added, removed []*ManagedJob
+	s := New(WithOnAdd(func(job *ManagedJob) {
+		mu.Lock()
+		added = append(added, job)
+		mu.Unlock()
+	}), WithOnRemove(func(job *ManagedJob) {
+		mu.Lock()
+		removed = append(removed, job)
+		mu.Unlock()
+	}), WithPanicHandler(func(job *ManagedJob, r interface{}) {
+		job.Cancel()
+	}))
+	defer s.Shutdown()
+	hooked := func() ([]*ManagedJob, []*ManagedJob) {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]*ManagedJob(nil), added...), append([]*ManagedJob(nil), removed...)
+	}
+
+	cancelled, _ := s.AfterFunc(time.Hour, func() {}, "cancelled")
+	terminated, _ := s.AfterFunc(10*time.Millisecond, func() {}, "terminated")
+	panicked, _ := s.PeriodFunc(10*time.Millisecond, time.Hour, func() { panic("test") }, "panicked")
+	a, r := hooked()
+	assert.Equal(t, []*ManagedJob{cancelled, terminated, panicked}, a)
+	assert.Empty(t, r)
+
+	cancelled.Cancel()
+	cancelled.Cancel()
+	<-time.After(50 * time.Millisecond)
+	_, r = hooked()
+	assert.Equal(t, 3, len(r))
+	assert.Equal(t, cancelled, r[0])
+	assert.Contains(t, r, terminated)
+	assert.Contains(t, r, panicked)
+}
+
+func TestScheduler_Disabled(t *testing.T) {
+	option, tick := WithManualTick()
+	s := New(option)
+	defer s.Shutdown()
+
+	var counter int32
+	mjob, _ := s.PeriodFunc(time.Hour, time.Hour, func() {
+		atomic.AddInt32(&counter, 1)
+	}, nil)
+	assert.True(t, mjob.Enabled())
+	start := mjob.NextTime()
+
+	mjob.SetEnabled(false)
+	assert.False(t, mjob.Enabled())
+	tick(start)
+	tick(start.Add(time.Hour))
+	assert.EqualValues(t, 0, atomic.LoadInt32(&counter))
+	assert.Equal(t, start.Add(2*time.Hour), mjob.NextTime())
+	assert.Equal(t, 1, len(s.Jobs()))
+
+	mjob.SetEnabled(true)
+	tick(start.Add(2 * time.Hour))
+	assert.EqualValues(t, 1, atomic.LoadInt32(&counter))
+	assert.Equal(t, start.Add(3*time.Hour), mjob.NextTime())
+}
+
+func TestScheduler_CancelOK(t *testing.T) {
+	s := New()
+
+	mjob, _ := s.AfterFunc(time.Hour, func() {}, nil)
+	assert.True(t, mjob.CancelOK())
+	assert.False(t, mjob.CancelOK())
+	assert.Equal(t, 0, len(s.Jobs()))
+
+	// once job completed
+	once, _ := s.AfterFunc(time.Millisecond, func() {}, nil)
+	<-time.After(20 * time.Millisecond)
+	assert.False(t, once.CancelOK())
+
+	// staged by Replace
+	var staged *ManagedJob
+	s.Replace(func(stage *Staging) (err error) {
+		staged, err = stage.PostFunc(&afterSchedule{delay: time.Hour}, func() {}, nil)
+		return
+	})
+	assert.True(t, staged.CancelOK())
+
+	mjob, _ = s.AfterFunc(time.Hour, func() {}, nil)
+	s.Shutdown()
+	assert.False(t, mjob.CancelOK())
+}
+
+func TestScheduler_Latency(t *testing.T) {
+	option, tick := WithManualTick()
+	s := New(option)
+	defer s.Shutdown()
+
+	mjob1, _ := s.PeriodFunc(time.Hour, time.Hour, func() {}, nil)
+	mjob2, _ := s.PeriodFunc(3*time.Hour, time.Hour, func() {}, nil)
+	assert.Equal(t, Stats{}, s.Stats())
+	start := mjob1.NextTime()
+
+	tick(start) // on time
+	assert.Equal(t, time.Duration(0), mjob1.LastLatency())
+
+	// the run loop is delayed
+	tick(start.Add(time.Hour + 30*time.Second))
+	assert.Equal(t, 30*time.Second, mjob1.LastLatency())
+	assert.Equal(t, time.Duration(0), mjob2.LastLatency()) // never fired
+
+	stats := s.Stats()
+	assert.EqualValues(t, 2, stats.Fires)
+	assert.Equal(t, 30*time.Second, stats.MaxLatency)
+	assert.Equal(t, 15*time.Second, stats.AvgLatency)
+}
+
+func TestScheduler_LatencySerialized(t *testing.T) {
+	s := New(WithInlineExecution()) // the busy job blocks the run loop
+	defer s.Shutdown()
+
+	busy, _ := s.AfterFunc(time.Millisecond, func() {
+		<-time.After(100 * time.Millisecond)
+	}, "busy")
+	done := make(chan struct{})
+	mjob, _ := s.AfterFunc(10*time.Millisecond, func() { close(done) }, "delayed")
+	<-done
+
+	assert.True(t, busy.LastLatency() < 50*time.Millisecond)
+	assert.True(t, mjob.LastLatency() >= 80*time.Millisecond, mjob.LastLatency())
+	stats := s.Stats()
+	assert.EqualValues(t, 2, stats.Fires)
+	assert.Equal(t, mjob.LastLatency(), stats.MaxLatency)
+	assert.True(t, stats.AvgLatency >= 40*time.Millisecond)
+}
+
+func TestScheduler_MissedSince(t *testing.T) {
+	s := New(WithLocation(time.UTC))
+	defer s.Shutdown()
+
+	daily := cron.MustParse("0 0 3 * * *")
+	lastKnown := time.Now().Add(-48 * time.Hour)
+	missed := s.MissedSince(daily, lastKnown)
+	if assert.Equal(t, 2, len(missed)) {
+		assert.Equal(t, daily.Next(lastKnown.In(time.UTC)), missed[0])
+		assert.Equal(t, missed[0].Add(24*time.Hour), missed[1])
+		assert.True(t, missed[1].Before(time.Now()))
+	}
+
+	assert.Empty(t, s.MissedSince(daily, time.Now()))
+	assert.Equal(t, maxMissed, len(s.MissedSince(cron.MustParse("* * * * * *"), lastKnown)))
+}
+
+type memLocker struct {
+	mu   sync.Mutex
+	held map[interface{}]bool
+}
+
+func (l *memLocker) TryLock(tag interface{}) (bool, func()) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.held[tag] {
+		return false, nil
+	}
+	l.held[tag] = true
+	return true, func() {
+		l.mu.Lock()
+		delete(l.held, tag)
+		l.mu.Unlock()
+	}
+}
+
+func TestScheduler_Locker(t *testing.T) {
+	locker := &memLocker{held: make(map[interface{}]bool)}
+	option1, tick1 := WithManualTick()
+	option2, tick2 := WithManualTick()
+	s1 := New(option1, WithLocker(locker))
+	defer s1.Shutdown()
+	s2 := New(option2, WithLocker(locker))
+	defer s2.Shutdown()
+
+	var counter int32
+	started, release := make(chan struct{}, 1), make(chan struct{})
+	job := func() {
+		atomic.AddInt32(&counter, 1)
+		started <- struct{}{}
+		<-release
+	}
+	mjob1, _ := s1.PeriodFunc(time.Hour, time.Hour, job, "singleton")
+	mjob2, _ := s2.PeriodFunc(time.Hour, time.Hour, job, "singleton")
+
+	// the instance 1 holds the lock
+	ticked1 := make(chan struct{})
+	go func() {
+		tick1(mjob1.NextTime())
+		close(ticked1)
+	}()
+	<-started
+	tick2(mjob2.NextTime())
+	assert.EqualValues(t, 1, atomic.LoadInt32(&counter))
+	assert.Equal(t, 0, mjob2.RunCount(), "the skipped run is not counted")
+	assert.False(t, mjob2.HasRun())
+	close(release)
+	<-ticked1
+
+	// the lock is released
+	tick2(mjob2.NextTime())
+	<-started
+	assert.EqualValues(t, 2, atomic.LoadInt32(&counter))
+	assert.Equal(t, 1, mjob2.RunCount())
+}
+
+func TestManagedJob_RunSync(t *testing.T) {
+	var recovered interface{}
+	s := New(WithPanicHandler(func(job *ManagedJob, r interface{}) {
+		recovered = r
+	}))
+	defer s.Shutdown()
+
+	ran := false
+	mjob, _ := s.AfterFunc(time.Hour, func() { ran = true }, nil)
+	next := mjob.NextTime()
+	mjob.RunSync()
+	assert.True(t, ran) // inline
+	assert.Equal(t, 1, mjob.RunCount())
+	assert.Equal(t, next, mjob.NextTime())
+
+	panicked, _ := s.AfterFunc(time.Hour, func() { panic("warm-up") }, nil)
+	panicked.RunSync()
+	assert.Equal(t, "warm-up", recovered)
+	assert.Equal(t, 1, panicked.RunCount())
+
+	// the runs by the scheduler are counted too
+	period, _ := s.PeriodFunc(time.Millisecond, time.Hour, func() {}, nil)
+	<-time.After(20 * time.Millisecond)
+	assert.Equal(t, 1, period.RunCount())
+}
+
+func TestManagedJob_RunSyncCancelled(t *testing.T) {
+	s := New()
+	defer s.Shutdown()
+
+	ran := 0
+	mjob, _ := s.AfterFunc(time.Hour, func() { ran++ }, nil)
+	mjob.Cancel()
+	assert.Error(t, mjob.WaitNext(context.Background()))
+
+	assert.NotPanics(t, func() {
+		mjob.RunSync()
+		mjob.RunSync()
+	})
+	assert.Equal(t, 2, ran)
+	assert.Equal(t, 2, mjob.RunCount())
+}
+
+type timedJob struct {
+	mu    sync.Mutex
+	times []time.Time
+}
+
+func (tj *timedJob) Run() {
+	panic("must not be called")
+}
+
+func (tj *timedJob) RunAt(t time.Time) {
+	tj.mu.Lock()
+	tj.times = append(tj.times, t)
+	tj.mu.Unlock()
+}
+
+func TestManagedJob_RunAt(t *testing.T) {
+	option, tick := WithManualTick()
+	s := New(option)
+	defer s.Shutdown()
+
+	tj := &timedJob{}
+	mjob, _ := s.Period(time.Hour, time.Hour, tj, nil)
+	start := mjob.NextTime()
+
+	tick(start.Add(time.Minute)) // the scheduled time is passed
+	backfill := start.Add(-24 * time.Hour)
+	mjob.RunAt(backfill)
+	assert.Equal(t, []time.Time{start, backfill}, tj.times)
+	assert.Equal(t, 2, mjob.RunCount())
+	assert.Equal(t, start.Add(time.Hour), mjob.NextTime())
+
+	// plain jobs ignore the time, and panics are recovered
+	var counter int32
+	plain, _ := s.AfterFunc(time.Hour, func() {
+		atomic.AddInt32(&counter, 1)
+		panic("test")
+	}, nil)
+	plain.RunAt(backfill)
+	assert.EqualValues(t, 1, atomic.LoadInt32(&counter))
+}
+
+func TestManagedJob_RunAtRemoved(t *testing.T) {
+	option, tick := WithManualTick()
+	s := New(option)
+	defer s.Shutdown()
+
+	tj := &timedJob{}
+	once, _ := s.After(time.Hour, tj, nil)
+	start := once.NextTime()
+	tick(start) // completed and removed
+	cancelled, _ := s.Period(time.Hour, time.Hour, tj, nil)
+	cancelled.Cancel()
+
+	backfill := start.Add(-24 * time.Hour)
+	assert.NotPanics(t, func() {
+		once.RunAt(backfill)
+		cancelled.RunAt(backfill)
+		cancelled.RunAt(backfill)
+	})
+	assert.Equal(t, []time.Time{start, backfill, backfill, backfill}, tj.times)
+	assert.Equal(t, 2, once.RunCount())
+	assert.Equal(t, 2, cancelled.RunCount())
+}
+
+func TestScheduler_CronEveryAligned(t *testing.T) {
+	layout := "15:04:05"
+	tests := []struct {
+		spec  string
+		from  string
+		nexts []string
+	}{
+		{"@every 15m@:00", "10:07:30", []string{"10:15:00", "10:30:00", "10:45:00", "11:00:00", "11:15:00"}},
+		{"@every 15m@:00", "10:15:00", []string{"10:30:00"}},
+		{"@every 15m@:05", "10:07:30", []string{"10:20:00", "10:35:00", "10:50:00", "11:05:00"}},
+		{"@every 20s@:00:10", "10:00:00", []string{"10:00:10", "10:00:30", "10:00:50", "10:01:10"}},
+		{"@every 6h@01:30", "00:00:00", []string{"01:30:00", "07:30:00", "13:30:00", "19:30:00", "01:30:00"}},
+		{"@every 6h@13:30", "00:00:00", []string{"01:30:00", "07:30:00"}},
+	}
+
+	for _, test := range tests {
+		schedule, err := parseCron(test.spec)
+		if !assert.NoError(t, err, test.spec) {
+			continue
+		}
+		next, _ := time.Parse(layout, test.from)
+		next = time.Date(2020, 4, 24, next.Hour(), next.Minute(), next.Second(), 0, time.Local)
+		for _, want := range test.nexts {
+			next = schedule.Next(next)
+			assert.Equal(t, want, next.Format(layout), test.spec)
+		}
+	}
+
+	for _, spec := range []string{"@every 7m@:00", "@every 15m@:60", "@every 15m@x", "@every 15m@",
+		"@every 15m@:", "@every 5h@00:00", "@every 2h@:00", "@every 1h@24:00", "@every 1m@:00:00:00"} {
+		_, err := parseCron(spec)
+		assert.Error(t, err, spec)
+	}
+}
+
+func TestScheduler_CronEveryDaysWeeks(t *testing.T) {
+	from := time.Date(2020, 3, 1, 10, 0, 0, 0, time.UTC)
+	tests := []struct {
+		spec   string
+		period time.Duration
+	}{
+		{"@every 1d", 24 * time.Hour},
+		{"@every 2w", 336 * time.Hour},
+		{"@every 1w2d", 9 * 24 * time.Hour},
+		{"@every 1d12h", 36 * time.Hour},
+		{"@every 90m", 90 * time.Minute},
+	}
+	for _, test := range tests {
+		schedule, err := parseCron(test.spec)
+		if !assert.NoError(t, err, test.spec) {
+			continue
+		}
+		next := schedule.Next(from)
+		assert.Equal(t, test.period, next.Sub(from), test.spec)
+		assert.Equal(t, test.period, schedule.Next(next).Sub(next), test.spec)
+	}
+
+	schedule, err := parseCron("@every 1d@01:30")
+	if assert.NoError(t, err) {
+		assert.Equal(t, "2020-03-02 01:30", schedule.Next(from).Format("2006-01-02 15:04"))
+	}
+
+	for _, spec := range []string{"@every 1.5d", "@every -1d", "@every d", "@every 1h2d", "@every 1x1d"} {
+		_, err := parseCron(spec)
+		assert.Error(t, err, spec)
+	}
+}
+
+func TestScheduler_CronFractionalSeconds(t *testing.T) {
+	layout := "15:04:05.000"
+	tests := []struct {
+		spec  string
+		from  string
+		nexts []string
+	}{
+		{"*/0.5 * * * * *", "10:00:00.000", []string{"10:00:00.500", "10:00:01.000", "10:00:01.500", "10:00:02.000"}},
+		{"*/0.5 * * * * *", "10:00:59.700", []string{"10:01:00.000", "10:01:00.500"}},
+		{"*/0.25 30 10 * * *", "10:29:59.000", []string{"10:30:00.000", "10:30:00.250", "10:30:00.500"}},
+		{"*/0.25 30 10 * * *", "10:30:59.900", []string{"10:30:00.000"}}, // the next day
+		{"*/1.5 * * * * *", "10:00:58.000", []string{"10:00:58.500", "10:01:00.000", "10:01:01.500"}},
+	}
+
+	for _, test := range tests {
+		schedule, err := parseCron(test.spec)
+		if !assert.NoError(t, err, test.spec) {
+			continue
+		}
+		next, _ := time.Parse(layout, test.from)
+		next = time.Date(2020, 4, 24, next.Hour(), next.Minute(), next.Second(), next.Nanosecond(), time.UTC)
+		for _, want := range test.nexts {
+			next = schedule.Next(next)
+			assert.Equal(t, want, next.Format(layout), test.spec)
+		}
+	}
+
+	s := NewManual(WithLocation(time.UTC))
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	s.Advance(start)
+	var fires int
+	mjob, err := s.CronFunc("*/0.5 * * * * *", func() { fires++ }, nil)
+	if assert.NoError(t, err) {
+		assert.Equal(t, start.Add(500*time.Millisecond), mjob.NextTime())
+		assert.Equal(t, "*/0.5 * * * * *", mjob.ScheduleString())
+		s.Advance(start.Add(3 * time.Second))
+		assert.Equal(t, 6, fires)
+	}
+
+	for _, spec := range []string{"*/0.0001 * * * * *", "*/60.5 * * * * *", "*/x.5 * * * * *",
+		"0 */0.5 * * * *", "* * */1.5 * * *", "*/0.5 60 * * * *"} {
+		_, err := parseCron(spec)
+		assert.Error(t, err, spec)
+	}
+	_, err = cron.Parse("0 */0.5 * * * *")
+	if assert.Error(t, err) {
+		assert.Equal(t, "syntax error in minute field: '*/0.5', the fractional step is not supported", err.Error())
+	}
+}
+
+func TestScheduler_InlineExecution(t *testing.T) {
+	option, tick := WithManualTick()
+	s := New(option, WithInlineExecution())
+	defer s.Shutdown()
+
+	// shared without locking, the race detector reports the concurrent runs
+	var runs []int
+	running := false
+	for i := 0; i < 3; i++ {
+		i := i
+		s.PeriodFunc(time.Hour, time.Hour, func() {
+			assert.False(t, running)
+			running = true
+			<-time.After(time.Millisecond)
+			runs = append(runs, i)
+			running = false
+		}, nil)
+	}
+	start := s.Jobs()[0].NextTime().Add(time.Second) // all the jobs are due
+
+	tick(start.Add(2 * time.Hour))
+	assert.Equal(t, 9, len(runs))
+
+	tick(start.Add(3 * time.Hour))
+	assert.Equal(t, 12, len(runs))
+}
+
+func TestManagedJob_NextComputeTime(t *testing.T) {
+	computeTime := func(spec string) time.Duration {
+		option, tick := WithManualTick()
+		s := New(option)
+		defer s.Shutdown()
+
+		mjob, _ := s.CronFunc(spec, func() {}, nil)
+		assert.True(t, mjob.NextComputeTime() > 0) // the registration
+		for i := 0; i < 3; i++ {
+			tick(mjob.NextTime())
+		}
+		return mjob.NextComputeTime()
+	}
+
+	// the fifth Friday of February walks through the years,
+	// the minimums of several runs exclude the noises.
+	var simple, heavy time.Duration = time.Hour, time.Hour
+	for i := 0; i < 5; i++ {
+		if d := computeTime("0 0 * * * *"); d < simple {
+			simple = d
+		}
+		if d := computeTime("0 0 0 ? 2 5#5"); d < heavy {
+			heavy = d
+		}
+	}
+	assert.True(t, heavy > simple, "heavy: %v, simple: %v", heavy, simple)
+}
+
+func TestManagedJob_ScheduleString(t *testing.T) {
+	s := New()
+	defer s.Shutdown()
+
+	job, err := s.CronFunc("0 30 9 * * MON-FRI", func() {}, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "0 30 9 * * 1-5", job.ScheduleString())
+
+	job, err = s.PostFunc(Minus(cron.MustParse("0 0 * * *"), cron.MustParse("0 0 1 * *")), func() {}, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "minus(0 0 * * *, 0 0 1 * *)", job.ScheduleString())
+
+	job, err = s.PeriodFunc(time.Hour, time.Hour, func() {}, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "", job.ScheduleString())
+}
+
+func TestManagedJob_ScheduleStringRoundTrip(t *testing.T) {
+	s := New()
+	defer s.Shutdown()
+
+	for _, spec := range []string{
+		"0 30 9 * * MON-FRI",
+		"TZ=Asia/Tokyo 0 0 9 * * *",
+		"0 0 12 * * * # lunch",
+		"@weekly",
+		"union(0 0 9 * * MON-FRI, minus(TZ=UTC 0 0 12 * * *, 0 0 12 1 * *))",
+	} {
+		schedule, err := ParseSchedule(spec)
+		assert.NoError(t, err, spec)
+		job, err := s.PostFunc(schedule, func() {}, nil)
+		assert.NoError(t, err, spec)
+
+		parsed, err := ParseSchedule(job.ScheduleString())
+		assert.NoError(t, err, job.ScheduleString())
+		assert.True(t, sameSpec(schedule, parsed), "%s -> %s", spec, job.ScheduleString())
+	}
+}
+
+// sameSpec reports whether a and b are composed of equal cron expressions.
+func sameSpec(a, b Schedule) bool {
+	if ea, ok := a.(*cron.Expression); ok {
+		eb, ok := b.(*cron.Expression)
+		return ok && ea.Equal(eb)
+	}
+	ca, ok := a.(CompositeSchedule)
+	if !ok {
+		return false
+	}
+	cb, ok := b.(CompositeSchedule)
+	if !ok || ca.Op() != cb.Op() {
+		return false
+	}
+	la, ra := ca.Operands()
+	lb, rb := cb.Operands()
+	return sameSpec(la, lb) && sameSpec(ra, rb)
+}
+
+type correlationKey struct{}
+
+type contextJob chan interface{}
+
+func (cj contextJob) Run() {}
+
+func (cj contextJob) RunContext(ctx context.Context) {
+	cj <- ctx.Value(correlationKey{})
+}
+
+func TestManagedJob_Value(t *testing.T) {
+	s := New()
+	defer s.Shutdown()
+
+	job := make(contextJob, 1)
+	mjob, err := s.Post(&afterSchedule{delay: 10 * time.Millisecond}, job, nil, WithJobValue(correlationKey{}, "req-42"))
+	assert.NoError(t, err)
+	assert.Equal(t, "req-42", mjob.Value(correlationKey{}))
+	assert.Nil(t, mjob.Value("other"))
+
+	select {
+	case v := <-job:
+		assert.Equal(t, "req-42", v)
+	case <-time.After(time.Second):
+		t.Fatal("job not run")
+	}
+}
+
+func TestScheduler_Dump(t *testing.T) {
+	s := NewManual(WithLocation(time.UTC))
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	s.Advance(start)
+
+	_, err := s.CronFunc("0 30 9 * * *", func() {}, "daily-report")
+	assert.NoError(t, err)
+	mjob, err := s.PeriodFunc(time.Hour, time.Hour, func() {}, "hourly-sync")
+	assert.NoError(t, err)
+	mjob.SetEnabled(false)
+	s.Advance(start.Add(time.Hour))
+
+	var buf strings.Builder
+	s.Dump(&buf)
+	dump := buf.String()
+	t.Log("\n" + dump)
+
+	lines := strings.Split(strings.TrimSpace(dump), "\n")
+	assert.Equal(t, 4, len(lines))
+	assert.Contains(t, lines[0], "2 jobs")
+	assert.Contains(t, lines[2], "hourly-sync") // sorted by the next time
+	assert.Contains(t, lines[2], "2020-01-01T02:00:00Z")
+	assert.Contains(t, lines[2], "false")
+	assert.Contains(t, lines[3], "daily-report")
+	assert.Contains(t, lines[3], "2020-01-01T09:30:00Z")
+	assert.Contains(t, lines[3], "0 30 9 * * *")
+}
+
+// steppedClock is the system clock with the wall clock stepped by offset.
+type steppedClock struct {
+	offset int64
+}
+
+func (c *steppedClock) Now() time.Time {
+	return time.Now().Add(time.Duration(atomic.LoadInt64(&c.offset)))
+}
+
+func (c *steppedClock) Monotonic() time.Duration {
+	return systemClock{}.Monotonic()
+}
+
+func (c *steppedClock) step(d time.Duration) {
+	atomic.AddInt64(&c.offset, int64(d))
+}
+
+func TestScheduler_ClockStep(t *testing.T) {
+	clock := &steppedClock{}
+	s := New(WithClock(clock))
+	defer s.Shutdown()
+
+	var counter int32
+	_, err := s.PeriodFunc(20*time.Millisecond, 20*time.Millisecond, func() {
+		atomic.AddInt32(&counter, 1)
+	}, nil)
+	assert.NoError(t, err)
+	cronJob, err := s.CronFunc("0 0 0 1 1 *", func() {}, nil)
+	assert.NoError(t, err)
+	cronNext := cronJob.NextTime()
+
+	<-time.After(110 * time.Millisecond)
+	assert.InDelta(t, 5, atomic.LoadInt32(&counter), 2)
+
+	clock.step(time.Hour) // no catch-up of the fires in the hour
+	<-time.After(100 * time.Millisecond)
+	assert.InDelta(t, 10, atomic.LoadInt32(&counter), 3)
+
+	clock.step(-2 * time.Hour) // no stall for the hours
+	<-time.After(100 * time.Millisecond)
+	assert.InDelta(t, 15, atomic.LoadInt32(&counter), 4)
+
+	assert.True(t, cronNext.Equal(cronJob.NextTime())) // follows the wall clock
+}
+
+func TestScheduler_JobsWithLabel(t *testing.T) {
+	s := New()
+	defer s.Shutdown()
+
+	labels := map[string]string{"team": "billing", "env": "prod"}
+	invoice, _ := s.PostFunc(cron.MustParse("0 0 1 * *"), func() {}, "invoice", WithLabels(labels))
+	labels["env"] = "dev" // copied at registration
+	refund, _ := s.PostFunc(cron.MustParse("0 0 * * *"), func() {}, "refund",
+		WithLabels(map[string]string{"team": "billing", "env": "dev"}))
+	report, _ := s.PostFunc(cron.MustParse("0 9 * * MON"), func() {}, "report",
+		WithLabels(map[string]string{"team": "sales", "env": "prod"}))
+	s.PostFunc(cron.MustParse("0 0 * * *"), func() {}, "nolabel")
+
+	tags := func(jobs []*ManagedJob) (tags []string) {
+		for _, j := range jobs {
+			tags = append(tags, j.Tag().(string))
+		}
+		sort.Strings(tags)
+		return
+	}
+	assert.Equal(t, []string{"invoice", "refund"}, tags(s.JobsWithLabel("team", "billing")))
+	assert.Equal(t, []string{"invoice", "report"}, tags(s.JobsWithLabel("env", "prod")))
+	assert.Nil(t, s.JobsWithLabel("team", "ops"))
+	assert.Nil(t, s.JobsWithLabel("owner", ""))
+
+	invoice.Labels()["team"] = "ops" // a copy
+	assert.Equal(t, "billing", invoice.Labels()["team"])
+
+	refund.Cancel()
+	assert.Equal(t, []string{"invoice"}, tags(s.JobsWithLabel("team", "billing")))
+	assert.Equal(t, map[string]string{"team": "sales", "env": "prod"}, report.Labels())
+}
+
+func TestScheduler_Priority(t *testing.T) {
+	s := NewManual(WithLocation(time.UTC))
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	s.Advance(start)
+
+	var order []string
+	post := func(name string, options ...JobOption) {
+		_, err := s.PostFunc(cron.MustParse("0 0 * * *"), func() {
+			order = append(order, name)
+		}, nil, options...)
+		assert.NoError(t, err)
+	}
+	post("default-1")
+	post("low", WithPriority(-1))
+	post("high", WithPriority(10))
+	post("default-2")
+	post("mid", WithPriority(5))
+	post("high-2", WithPriority(10))
+
+	s.Advance(start.Add(24 * time.Hour))
+	assert.Equal(t, []string{"high", "high-2", "mid", "default-1", "default-2", "low"}, order)
+
+	order = nil
+	s.Advance(start.Add(48 * time.Hour))
+	assert.Equal(t, []string{"high", "high-2", "mid", "default-1", "default-2", "low"}, order)
+}
+
+func TestScheduler_ConcurrentShutdown(t *testing.T) {
+	s := New()
+	var jobs []*ManagedJob
+	for i := 0; i < 10; i++ {
+		mjob, err := s.PeriodFunc(time.Millisecond, time.Millisecond, func() {}, i)
+		assert.NoError(t, err)
+		jobs = append(jobs, mjob)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(5)
+		go func() {
+			defer wg.Done()
+			s.Shutdown()
+		}()
+		go func() {
+			defer wg.Done()
+			s.ShutdownAndWait()
+		}()
+		go func(mjob *ManagedJob) {
+			defer wg.Done()
+			mjob.Cancel()
+		}(jobs[i])
+		go func() {
+			defer wg.Done()
+			if mjob, err := s.AfterFunc(time.Millisecond, func() {}, nil); err == nil {
+				mjob.Cancel()
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			s.Jobs()
+			s.Terminated()
+		}()
+	}
+	wg.Wait()
+
+	assert.True(t, s.Terminated())
+	s.Shutdown()
+	s.ShutdownAndWait()
+	_, err := s.AfterFunc(0, func() {}, nil)
+	assert.EqualError(t, err, "scheduler is terminated")
+	jobs[0].Cancel()
+	assert.False(t, jobs[0].CancelOK())
+	assert.Nil(t, s.Jobs())
+	assert.Equal(t, 0, s.Count())
+}
+
+func TestScheduler_PerTagConcurrency(t *testing.T) {
+	limits := map[interface{}]int{"report": 2, "export": 1}
+	s := New(WithPerTagConcurrency(func(tag interface{}) int {
+		return limits[tag]
+	}))
+	defer s.Shutdown()
+
+	var mu sync.Mutex
+	running, peak, runs := map[interface{}]int{}, map[interface{}]int{}, map[interface{}]int{}
+	post := func(tag string) {
+		_, err := s.PeriodFunc(0, 10*time.Millisecond, func() {
+			mu.Lock()
+			running[tag]++
+			runs[tag]++
+			if running[tag] > peak[tag] {
+				peak[tag] = running[tag]
+			}
+			mu.Unlock()
+
+			<-time.After(50 * time.Millisecond) // overlaps the next runs
+			mu.Lock()
+			running[tag]--
+			mu.Unlock()
+		}, tag)
+		assert.NoError(t, err)
+	}
+	for i := 0; i < 3; i++ {
+		post("report")
+		post("export")
+		post("other")
+	}
+
+	<-time.After(200 * time.Millisecond)
+	s.ShutdownAndWait()
+
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Equal(t, 2, peak["report"])
+	assert.Equal(t, 1, peak["export"])
+	assert.True(t, peak["other"] > 3, "unlimited tag")
+	assert.True(t, runs["report"] > 2)
+	assert.True(t, runs["export"] > 1)
+}
+
+func TestScheduler_PerTagConcurrencySkipped(t *testing.T) {
+	option, tick := WithManualTick()
+	s := New(option, WithPerTagConcurrency(func(tag interface{}) int { return 1 }))
+	defer s.Shutdown()
+
+	started, release := make(chan struct{}, 1), make(chan struct{})
+	busy, _ := s.PeriodFunc(time.Hour, time.Hour, func() {
+		started <- struct{}{}
+		<-release
+	}, "export")
+	mjob, _ := s.PeriodFunc(2*time.Hour, time.Hour, func() {}, "export")
+	start := busy.NextTime()
+
+	ticked := make(chan struct{})
+	go func() {
+		tick(start)
+		close(ticked)
+	}()
+	<-started
+	tick(mjob.NextTime()) // the busy job holds the budget of the tag
+	assert.Equal(t, 0, mjob.RunCount(), "the skipped run is not counted")
+	assert.False(t, mjob.HasRun())
+	close(release)
+	<-ticked
+
+	busy.Cancel()
+	tick(mjob.NextTime())
+	assert.Equal(t, 1, mjob.RunCount())
+}
+
+func TestScheduler_MinWake(t *testing.T) {
+	s := New(WithMinWake(100 * time.Millisecond))
+	defer s.Shutdown()
+
+	var mu sync.Mutex
+	var fired []time.Time
+	start := time.Now()
+	for i := 1; i <= 4; i++ {
+		_, err := s.AfterFunc(time.Duration(i)*5*time.Millisecond, func() {
+			mu.Lock()
+			fired = append(fired, time.Now())
+			mu.Unlock()
+		}, i)
+		assert.NoError(t, err)
+	}
+
+	<-time.After(300 * time.Millisecond)
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Equal(t, 4, len(fired))
+	for _, at := range fired {
+		assert.True(t, at.Sub(start) >= 100*time.Millisecond, "coalesced to the min wake")
+	}
+	assert.True(t, s.LastWakeInterval() >= 100*time.Millisecond)
+}
+
+func TestManagedJob_PauseResume(t *testing.T) {
+	s := NewManual(WithLocation(time.UTC))
+	start := time.Date(2020, 1, 1, 8, 0, 0, 0, time.UTC)
+	s.Advance(start)
+
+	var daily, reset, phased int
+	dailyJob, _ := s.CronFunc("0 0 9 * * *", func() { daily++ }, nil)
+	resetJob, _ := s.PeriodFunc(time.Hour, time.Hour, func() { reset++ }, nil)
+	phasedJob, _ := s.Post(&periodSchedule{initialDelay: time.Hour, period: time.Hour},
+		JobFunc(func() { phased++ }), nil, ResumePreservePhase())
+
+	s.Advance(start.Add(time.Hour)) // 09:00
+	assert.Equal(t, []int{1, 1, 1}, []int{daily, reset, phased})
+
+	dailyJob.Pause()
+	resetJob.Pause()
+	phasedJob.Pause()
+	s.Advance(start.Add(6 * time.Hour)) // 14:00
+	assert.Equal(t, []int{1, 1, 1}, []int{daily, reset, phased})
+
+	s.Advance(start.Add(6*time.Hour + 30*time.Minute)) // 14:30
+	dailyJob.Resume()
+	resetJob.Resume()
+	phasedJob.Resume()
+	assert.True(t, dailyJob.Enabled())
+	assert.Equal(t, start.Add(25*time.Hour), dailyJob.NextTime(), "09:00 tomorrow")
+	assert.Equal(t, start.Add(7*time.Hour+30*time.Minute), resetJob.NextTime(), "restarted at 14:30")
+	assert.Equal(t, start.Add(7*time.Hour), phasedJob.NextTime(), "on the original grid")
+
+	s.Advance(start.Add(7*time.Hour + 30*time.Minute))
+	assert.Equal(t, []int{1, 2, 2}, []int{daily, reset, phased})
+}
+
+type stalledSchedule struct {
+	called bool
+}
+
+func (ss *stalledSchedule) Next(t time.Time) time.Time {
+	if ss.called {
+		return t
+	}
+	ss.called = true
+	return t.Add(10 * time.Millisecond)
+}
+
+func TestScheduler_DeadLetter(t *testing.T) {
+	var mu sync.Mutex
+	reasons := make(map[interface{}]string)
+	removed := make(map[interface{}]bool)
+	s := New(WithPanicHandler(func(*ManagedJob, interface{}) {}),
+		WithDeadLetter(func(job *ManagedJob, reason string) {
+			mu.Lock()
+			reasons[job.Tag()] = reason
+			mu.Unlock()
+		}),
+		WithOnRemove(func(job *ManagedJob) {
+			mu.Lock()
+			removed[job.Tag()] = true
+			mu.Unlock()
+		}))
+	defer s.Shutdown()
+
+	var stalledRuns int32
+	s.PostFunc(&panicSchedule{}, func() {}, "panic")
+	s.PostFunc(&stalledSchedule{}, func() { atomic.AddInt32(&stalledRuns, 1) }, "stalled")
+	s.AfterFunc(10*time.Millisecond, func() {}, "exhausted")
+
+	<-time.After(100 * time.Millisecond)
+	assert.Equal(t, 0, s.Count())
+	assert.Equal(t, int32(1), atomic.LoadInt32(&stalledRuns))
+
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Equal(t, map[interface{}]string{
+		"panic":   DeadLetterPanic,
+		"stalled": DeadLetterStalled,
+	}, reasons)
+	assert.Equal(t, map[interface{}]bool{"panic": true, "stalled": true, "exhausted": true}, removed)
+}
+
+func TestScheduler_WhenPanic(t *testing.T) {
+	testPanickingSchedule(t, When(&periodSchedule{period: 10 * time.Millisecond}, func() bool {
+		panic("bad predicate")
+	}))
+}
+
+func TestScheduler_RisingEdgePanic(t *testing.T) {
+	testPanickingSchedule(t, OnRisingEdge(10*time.Millisecond, func() bool {
+		panic("bad condition")
+	}))
+}
+
+// testPanickingSchedule asserts the job of the schedule panicking on the run loop
+// is dead-lettered, and the other jobs keep firing.
+func testPanickingSchedule(t *testing.T, schedule Schedule) {
+	var panics int32
+	var mu sync.Mutex
+	reasons := make(map[interface{}]string)
+	s := New(WithPanicHandler(func(*ManagedJob, interface{}) {
+		atomic.AddInt32(&panics, 1)
+	}), WithDeadLetter(func(job *ManagedJob, reason string) {
+		mu.Lock()
+		reasons[job.Tag()] = reason
+		mu.Unlock()
+	}))
+	defer s.Shutdown()
+
+	var counter int32
+	s.PeriodFunc(0, 10*time.Millisecond, func() {
+		atomic.AddInt32(&counter, 1)
+	}, "good")
+	_, err := s.PostFunc(schedule, func() {}, "bad")
+	assert.NoError(t, err)
+
+	<-time.After(100 * time.Millisecond)
+	assert.EqualValues(t, 1, atomic.LoadInt32(&panics))
+	assert.True(t, atomic.LoadInt32(&counter) > 3, "the good job keeps firing")
+	assert.Equal(t, 1, s.Count())
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Equal(t, map[interface{}]string{"bad": DeadLetterPanic}, reasons)
+}
+
+// countingSchedule counts the times calculated by Next.
+type countingSchedule struct {
+	Schedule
+	count int32
+}
+
+func (cs *countingSchedule) Next(t time.Time) time.Time {
+	atomic.AddInt32(&cs.count, 1)
+	return cs.Schedule.Next(t)
+}
+
+func TestScheduler_Exclusive(t *testing.T) {
+	s := New()
+	defer s.Shutdown()
+
+	schedule := &countingSchedule{Schedule: &periodSchedule{period: 10 * time.Millisecond}}
+	release := make(chan struct{})
+	mjob, err := s.PostFunc(schedule, func() {
+		<-release // the first run blocks
+	}, "slow", Exclusive())
+	assert.NoError(t, err)
+
+	<-time.After(100 * time.Millisecond)
+	skipped := mjob.SkippedCount()
+	assert.True(t, skipped > 3, "skipped %d", skipped)
+	<-time.After(50 * time.Millisecond)
+	assert.True(t, mjob.SkippedCount() > skipped, "grows")
+
+	close(release)
+	s.ShutdownAndWait()
+
+	// every due time is either run or skipped, the last time is pending
+	due := int64(atomic.LoadInt32(&schedule.count)) - 1
+	assert.Equal(t, due, int64(mjob.RunCount())+mjob.SkippedCount())
+	assert.Equal(t, mjob.SkippedCount(), s.Stats().Skipped)
+	assert.Equal(t, int64(mjob.RunCount()), s.Stats().Fires)
+}
+
+func TestScheduler_IsIdle(t *testing.T) {
+	s := New()
+	defer s.Shutdown()
+	assert.True(t, s.IsIdle())
+
+	mjob, err := s.AfterFunc(time.Hour, func() {}, "pending")
+	assert.NoError(t, err)
+	s.Jobs() // wait for the run loop to publish
+	assert.False(t, s.IsIdle())
+
+	mjob.Cancel()
+	s.Jobs()
+	assert.True(t, s.IsIdle())
+
+	m := NewManual()
+	assert.True(t, m.IsIdle())
+	m.AfterFunc(time.Hour, func() {}, nil)
+	assert.False(t, m.IsIdle())
+}
+
+func TestScheduler_WithExecutor(t *testing.T) {
+	var launched int32
+	works := make(chan func(), 16)
+	pool := &sync.WaitGroup{}
+	for i := 0; i < 2; i++ { // a fixed pool of workers
+		pool.Add(1)
+		go func() {
+			defer pool.Done()
+			for work := range works {
+				work()
+			}
+		}()
+	}
+
+	s := New(WithExecutor(func(work func()) {
+		atomic.AddInt32(&launched, 1)
+		works <- work
+	}))
+
+	var runs int32
+	for i := 0; i < 3; i++ {
+		s.PeriodFunc(0, 10*time.Millisecond, func() {
+			<-time.After(5 * time.Millisecond)
+			atomic.AddInt32(&runs, 1)
+		}, i)
+	}
+
+	<-time.After(100 * time.Millisecond)
+	s.ShutdownAndWait()
+	assert.True(t, atomic.LoadInt32(&launched) > 3)
+	assert.Equal(t, atomic.LoadInt32(&launched), atomic.LoadInt32(&runs), "waited for all the runs")
+
+	close(works)
+	pool.Wait()
+}
+
+func TestScheduler_ExecutorPanic(t *testing.T) {
+	var calls, badRuns int32
+	var dropped func()
+	reasons := make(chan string, 1)
+	s := New(WithExecutor(func(work func()) {
+		if atomic.AddInt32(&calls, 1) == 1 {
+			dropped = work
+			panic("pool is full")
+		}
+		go work()
+	}), WithPanicHandler(func(job *ManagedJob, r interface{}) {}),
+		WithDeadLetter(func(job *ManagedJob, reason string) {
+			reasons <- reason
+		}))
+
+	bad, _ := s.AfterFunc(0, func() {
+		atomic.AddInt32(&badRuns, 1)
+	}, "bad")
+	assert.Equal(t, DeadLetterPanic, <-reasons)
+	<-bad.dead // the abandoned run is not left running
+
+	var counter int32
+	s.PeriodFunc(0, 10*time.Millisecond, func() {
+		atomic.AddInt32(&counter, 1)
+	}, "good")
+	<-time.After(50 * time.Millisecond)
+	assert.True(t, atomic.LoadInt32(&counter) > 2)
+
+	dropped() // the abandoned work does not run
+	assert.Equal(t, int32(0), atomic.LoadInt32(&badRuns))
+
+	done := make(chan struct{})
+	go func() {
+		s.ShutdownAndWait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("ShutdownAndWait waits for the abandoned run")
+	}
+}
+
+func TestScheduler_TotalFires(t *testing.T) {
+	s := New(WithPanicHandler(func(*ManagedJob, interface{}) {}))
+	assert.EqualValues(t, 0, s.TotalFires())
+
+	var mjobs []*ManagedJob
+	for i := 1; i <= 3; i++ {
+		mjob, _ := s.PeriodFunc(0, time.Duration(i)*5*time.Millisecond, func() {}, nil)
+		mjobs = append(mjobs, mjob)
+	}
+	panicked, _ := s.PeriodFunc(0, 5*time.Millisecond, func() { panic("test") }, nil)
+	mjobs = append(mjobs, panicked)
+
+	<-time.After(50 * time.Millisecond)
+	s.ShutdownAndWait()
+
+	var runs int64
+	for _, mjob := range mjobs {
+		runs += int64(mjob.RunCount())
+	}
+	assert.True(t, panicked.RunCount() > 0)
+	assert.Equal(t, runs, s.TotalFires())
+	assert.Equal(t, s.Stats().Fires, s.TotalFires())
+}
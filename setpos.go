@@ -0,0 +1,96 @@
+// Copyright (c) 2019,CAO HONGJU. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package scheduler
+
+import "time"
+
+const (
+	// maxSetPosMatches bounds the times of inner collected in a month.
+	maxSetPosMatches = 1 << 16
+	// maxSetPosMonths bounds the months searched for a selected time.
+	maxSetPosMonths = 1 << 12
+)
+
+// SetPos returns the schedule that fires at the times of inner at the given
+// ordinal positions within each calendar month, like the BYSETPOS of RRULE,
+// e.g. SetPos(cron.MustParse("0 0 9 * * MON-FRI"), -2) fires on the 2nd-to-last
+// workday of the month. The position 1 is the first time of the month, and -1 is
+// the last. The months are in the location of the time given to Next.
+//
+// The times of a month are collected from a clone of inner, so inner should be
+// a stateless schedule, e.g. a cron expression. At most 65536 times of a month
+// are collected.
+func SetPos(inner Schedule, positions ...int) Schedule {
+	sp := &setPos{inner: inner}
+	for _, pos := range positions {
+		if pos != 0 {
+			sp.positions = append(sp.positions, pos)
+		}
+	}
+	if len(sp.positions) == 0 {
+		return emptySchedule{}
+	}
+	return sp
+}
+
+type setPos struct {
+	inner     Schedule
+	positions []int
+	month     time.Time   // the start of the month selected last
+	selected  []time.Time // the selected times of the month, in order
+}
+
+func (sp *setPos) Clone() Schedule {
+	clone := *sp
+	return &clone
+}
+
+func (sp *setPos) Next(t time.Time) time.Time {
+	month := time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, t.Location())
+	for i := 0; i < maxSetPosMonths; i++ {
+		for _, selected := range sp.selectMonth(month) {
+			if selected.After(t) {
+				return selected
+			}
+		}
+
+		// jump to the month of the next time of inner
+		next := cloneSchedule(sp.inner).Next(month.AddDate(0, 1, 0).Add(-time.Nanosecond))
+		if next.IsZero() {
+			return time.Time{}
+		}
+		next = next.In(t.Location())
+		month = time.Date(next.Year(), next.Month(), 1, 0, 0, 0, 0, t.Location())
+	}
+	return time.Time{}
+}
+
+// selectMonth returns the times of inner at the positions in the month.
+func (sp *setPos) selectMonth(month time.Time) []time.Time {
+	if sp.month.Equal(month) && sp.month.Location() == month.Location() {
+		return sp.selected
+	}
+
+	end := month.AddDate(0, 1, 0)
+	inner := cloneSchedule(sp.inner)
+	var matches []time.Time
+	next := inner.Next(month.Add(-time.Nanosecond))
+	for len(matches) < maxSetPosMatches && !next.IsZero() && next.Before(end) {
+		matches = append(matches, next)
+		next = inner.Next(next)
+	}
+
+	var selected []time.Time
+	for i, match := range matches {
+		for _, pos := range sp.positions {
+			if pos == i+1 || pos == i-len(matches) {
+				selected = append(selected, match)
+				break
+			}
+		}
+	}
+	sp.month, sp.selected = month, selected
+	return selected
+}
@@ -0,0 +1,50 @@
+// Copyright (c) 2019,CAO HONGJU. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package scheduler
+
+import (
+	"testing"
+	"time"
+
+	"github.com/cnotch/scheduler/cron"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSetPos(t *testing.T) {
+	daily := cron.MustParse("0 0 12 * * *")
+	layout := "2006-01-02 15:04"
+	next := func(sched Schedule, from string, n int) []string {
+		t, _ := time.Parse(layout, from)
+		var times []string
+		for i := 0; i < n; i++ {
+			t = sched.Next(t)
+			times = append(times, t.Format(layout))
+		}
+		return times
+	}
+
+	assert.Equal(t, []string{"2020-01-31 12:00", "2020-02-29 12:00", "2020-03-31 12:00"},
+		next(SetPos(daily, -1), "2020-01-01 00:00", 3))
+	assert.Equal(t, []string{"2020-01-30 12:00", "2020-02-28 12:00", "2020-03-30 12:00"},
+		next(SetPos(daily, -2), "2020-01-01 00:00", 3))
+	assert.Equal(t, []string{"2020-01-31 12:00", "2020-02-01 12:00", "2020-02-29 12:00"},
+		next(SetPos(daily, 1, -1), "2020-01-30 12:00", 3))
+
+	// the 2nd-to-last workday
+	workdays := cron.MustParse("0 0 9 * * MON-FRI")
+	assert.Equal(t, []string{"2020-04-29 09:00", "2020-05-28 09:00"},
+		next(SetPos(workdays, -2), "2020-04-01 00:00", 2))
+
+	// the sparse months are skipped
+	quarterly := cron.MustParse("0 0 12 * 1,4,7,10 *")
+	assert.Equal(t, []string{"2020-04-30 12:00", "2020-07-31 12:00"},
+		next(SetPos(quarterly, -1), "2020-02-01 00:00", 2))
+
+	// the positions out of the month are ignored
+	assert.Equal(t, []string{"2020-01-31 12:00", "2020-03-31 12:00"},
+		next(SetPos(daily, 31), "2020-01-01 00:00", 2))
+	assert.True(t, SetPos(daily).Next(time.Now()).IsZero())
+	assert.True(t, SetPos(UnionAll(), -1).Next(time.Now()).IsZero())
+}
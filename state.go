@@ -0,0 +1,250 @@
+// Copyright (c) 2019,CAO HONGJU. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package scheduler
+
+import (
+	"bytes"
+	"encoding"
+	"encoding/binary"
+	"errors"
+	"time"
+)
+
+// The kinds of the persistable schedules in the binary state.
+const (
+	stateAfter byte = iota + 1
+	statePeriod
+	stateRepeat
+)
+
+var (
+	_ encoding.BinaryMarshaler   = (*afterSchedule)(nil)
+	_ encoding.BinaryUnmarshaler = (*afterSchedule)(nil)
+	_ encoding.BinaryMarshaler   = (*periodSchedule)(nil)
+	_ encoding.BinaryUnmarshaler = (*periodSchedule)(nil)
+	_ encoding.BinaryMarshaler   = (*repeatSchedule)(nil)
+	_ encoding.BinaryUnmarshaler = (*repeatSchedule)(nil)
+)
+
+// rewinder is implemented by the persistable schedules. rewind returns
+// the copy of the schedule before the pending fire was taken by Next,
+// first reports whether the pending fire is the first one.
+type rewinder interface {
+	rewind(first bool) encoding.BinaryMarshaler
+}
+
+// UnmarshalSchedule returns the schedule restored from the state marshaled
+// by ManagedJob.MarshalSchedule, e.g. after a restart. The restored schedule
+// continues from the position where it was marshaled.
+//
+// Only the schedules of After, Period and Repeat have the state to persist,
+// the others like the cron expressions are stateless, and need no persistence.
+func UnmarshalSchedule(data []byte) (Schedule, error) {
+	if len(data) == 0 {
+		return nil, errors.New("empty schedule state")
+	}
+
+	var schedule interface {
+		Schedule
+		encoding.BinaryUnmarshaler
+	}
+	switch data[0] {
+	case stateAfter:
+		schedule = &afterSchedule{}
+	case statePeriod:
+		schedule = &periodSchedule{}
+	case stateRepeat:
+		schedule = &repeatSchedule{}
+	default:
+		return nil, errors.New("unknown kind of schedule state")
+	}
+	if err := schedule.UnmarshalBinary(data); err != nil {
+		return nil, err
+	}
+	return schedule, nil
+}
+
+// MarshalSchedule returns the state of the job's schedule, which is restored
+// by UnmarshalSchedule. The state is taken on the run loop, consistent with
+// the fires of the job, and the pending fire of the job is not taken, so it
+// will be the first fire of the restored schedule.
+// It returns an error if the schedule has no state to persist.
+func (mjob *ManagedJob) MarshalSchedule() (data []byte, err error) {
+	marshaler, ok := mjob.Schelule().(encoding.BinaryMarshaler)
+	if !ok {
+		return nil, errors.New("schedule has no state to persist")
+	}
+	if !mjob.owner.exec(func(jobs *jobQueue) {
+		if r, ok := marshaler.(rewinder); ok &&
+			mjob.index >= 0 && mjob.index < len(*jobs) && (*jobs)[mjob.index] == mjob {
+			marshaler = r.rewind(mjob.prevTime.get().IsZero())
+		}
+		data, err = marshaler.MarshalBinary()
+	}) { // the run loop exited, no more fires
+		return marshaler.MarshalBinary()
+	}
+	return
+}
+
+func (at *afterSchedule) MarshalBinary() ([]byte, error) {
+	var w stateWriter
+	w.byte(stateAfter)
+	w.bool(at.called)
+	w.duration(at.delay)
+	return w.Bytes(), nil
+}
+
+func (at *afterSchedule) rewind(first bool) encoding.BinaryMarshaler {
+	return &afterSchedule{delay: at.delay}
+}
+
+func (at *afterSchedule) UnmarshalBinary(data []byte) error {
+	r := stateReader{kind: stateAfter, data: bytes.NewReader(data)}
+	r.header()
+	state := afterSchedule{called: r.bool(), delay: r.duration()}
+	if err := r.done(); err != nil {
+		return err
+	}
+	*at = state
+	return nil
+}
+
+func (pt *periodSchedule) MarshalBinary() ([]byte, error) {
+	var w stateWriter
+	w.byte(statePeriod)
+	w.bool(pt.called)
+	w.duration(pt.initialDelay)
+	w.duration(pt.period)
+	return w.Bytes(), nil
+}
+
+func (pt *periodSchedule) rewind(first bool) encoding.BinaryMarshaler {
+	clone := *pt
+	clone.called = !first
+	return &clone
+}
+
+func (pt *periodSchedule) UnmarshalBinary(data []byte) error {
+	r := stateReader{kind: statePeriod, data: bytes.NewReader(data)}
+	r.header()
+	state := periodSchedule{called: r.bool(), initialDelay: r.duration(), period: r.duration()}
+	if err := r.done(); err != nil {
+		return err
+	}
+	if state.period < minInterval {
+		return errors.New("invalid period in schedule state")
+	}
+	*pt = state
+	return nil
+}
+
+func (rt *repeatSchedule) MarshalBinary() ([]byte, error) {
+	var w stateWriter
+	w.byte(stateRepeat)
+	w.int(int64(rt.called))
+	w.duration(rt.initialDelay)
+	w.duration(rt.gap)
+	w.int(int64(rt.times))
+	return w.Bytes(), nil
+}
+
+func (rt *repeatSchedule) rewind(first bool) encoding.BinaryMarshaler {
+	clone := *rt
+	if clone.called > 0 {
+		clone.called--
+	}
+	return &clone
+}
+
+func (rt *repeatSchedule) UnmarshalBinary(data []byte) error {
+	r := stateReader{kind: stateRepeat, data: bytes.NewReader(data)}
+	r.header()
+	state := repeatSchedule{called: int(r.int()), initialDelay: r.duration(), gap: r.duration(), times: int(r.int())}
+	if err := r.done(); err != nil {
+		return err
+	}
+	if state.called < 0 || state.times <= 0 || state.gap < minInterval {
+		return errors.New("invalid repeat in schedule state")
+	}
+	*rt = state
+	return nil
+}
+
+// stateWriter writes the fields of the schedule state.
+type stateWriter struct {
+	bytes.Buffer
+}
+
+func (w *stateWriter) byte(b byte) {
+	w.WriteByte(b)
+}
+
+func (w *stateWriter) bool(b bool) {
+	if b {
+		w.WriteByte(1)
+	} else {
+		w.WriteByte(0)
+	}
+}
+
+func (w *stateWriter) int(n int64) {
+	var buf [binary.MaxVarintLen64]byte
+	w.Write(buf[:binary.PutVarint(buf[:], n)])
+}
+
+func (w *stateWriter) duration(d time.Duration) {
+	w.int(int64(d))
+}
+
+// stateReader reads the fields of the schedule state, the first error is kept.
+type stateReader struct {
+	kind byte
+	data *bytes.Reader
+	err  error
+}
+
+func (r *stateReader) header() {
+	if b := r.byte(); r.err == nil && b != r.kind {
+		r.err = errors.New("mismatched kind of schedule state")
+	}
+}
+
+func (r *stateReader) byte() byte {
+	if r.err != nil {
+		return 0
+	}
+	b, err := r.data.ReadByte()
+	if err != nil {
+		r.err = errors.New("truncated schedule state")
+	}
+	return b
+}
+
+func (r *stateReader) bool() bool {
+	return r.byte() != 0
+}
+
+func (r *stateReader) int() int64 {
+	if r.err != nil {
+		return 0
+	}
+	n, err := binary.ReadVarint(r.data)
+	if err != nil {
+		r.err = errors.New("truncated schedule state")
+	}
+	return n
+}
+
+func (r *stateReader) duration() time.Duration {
+	return time.Duration(r.int())
+}
+
+// done returns the error of reading, or an error if there are extra bytes.
+func (r *stateReader) done() error {
+	if r.err == nil && r.data.Len() > 0 {
+		r.err = errors.New("extra bytes in schedule state")
+	}
+	return r.err
+}
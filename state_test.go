@@ -0,0 +1,90 @@
+// Copyright (c) 2019,CAO HONGJU. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package scheduler
+
+import (
+	"testing"
+	"time"
+
+	"github.com/cnotch/scheduler/cron"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMarshalSchedule_Repeat(t *testing.T) {
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	s := NewManual(WithLocation(time.UTC))
+	s.Advance(start)
+
+	job := &timedJob{}
+	mjob, err := s.Repeat(time.Second, 10*time.Second, 5, job, nil)
+	assert.NoError(t, err)
+	s.Advance(start.Add(15 * time.Second))
+	assert.Equal(t, 2, len(job.times))
+
+	// restart in the middle of the sequence
+	data, err := mjob.MarshalSchedule()
+	assert.NoError(t, err)
+	mjob.Cancel()
+
+	restart := start.Add(time.Hour)
+	s = NewManual(WithLocation(time.UTC))
+	s.Advance(restart)
+	schedule, err := UnmarshalSchedule(data)
+	assert.NoError(t, err)
+	_, err = s.Post(schedule, job, nil)
+	assert.NoError(t, err)
+	for i := 1; i <= 100; i++ {
+		s.Advance(restart.Add(time.Duration(i) * time.Second))
+	}
+
+	assert.Equal(t, []time.Time{
+		start.Add(time.Second),
+		start.Add(11 * time.Second),
+		restart.Add(10 * time.Second),
+		restart.Add(20 * time.Second),
+		restart.Add(30 * time.Second),
+	}, job.times)
+}
+
+func TestMarshalSchedule(t *testing.T) {
+	s := New()
+	defer s.Shutdown()
+
+	period, _ := s.PeriodFunc(time.Hour, time.Minute, func() {}, nil)
+	data, err := period.MarshalSchedule()
+	assert.NoError(t, err)
+	schedule, err := UnmarshalSchedule(data)
+	assert.NoError(t, err)
+	assert.Equal(t, &periodSchedule{called: false, initialDelay: time.Hour, period: time.Minute}, schedule)
+
+	after, _ := s.AfterFunc(time.Hour, func() {}, nil)
+	data, err = after.MarshalSchedule()
+	assert.NoError(t, err)
+	schedule, err = UnmarshalSchedule(data)
+	assert.NoError(t, err)
+	assert.Equal(t, &afterSchedule{delay: time.Hour}, schedule) // pending
+
+	s2 := NewManual()
+	period, _ = s2.PeriodFunc(time.Hour, time.Minute, func() {}, nil)
+	s2.Advance(time.Now().Add(time.Hour))
+	data, err = period.MarshalSchedule()
+	assert.NoError(t, err)
+	schedule, err = UnmarshalSchedule(data)
+	assert.NoError(t, err)
+	assert.Equal(t, &periodSchedule{called: true, initialDelay: time.Hour, period: time.Minute}, schedule)
+
+	cronJob, _ := s.PostFunc(cron.MustParse("0 0 * * *"), func() {}, nil)
+	_, err = cronJob.MarshalSchedule()
+	assert.EqualError(t, err, "schedule has no state to persist")
+
+	for _, data := range [][]byte{nil, {0}, {stateAfter}, {statePeriod, 1, 2}, append(data, 0)} {
+		_, err = UnmarshalSchedule(data)
+		assert.Error(t, err, data)
+	}
+	data, _ = (&periodSchedule{period: 0}).MarshalBinary()
+	_, err = UnmarshalSchedule(data)
+	assert.Error(t, err)
+	assert.Error(t, (&repeatSchedule{}).UnmarshalBinary(data)) // mismatched kind
+}
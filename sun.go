@@ -0,0 +1,111 @@
+// Copyright (c) 2019,CAO HONGJU. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package scheduler
+
+import (
+	"math"
+	"time"
+)
+
+// SunEvent is the solar event of a SunSchedule.
+type SunEvent int
+
+// The solar events.
+const (
+	Sunrise SunEvent = iota
+	Sunset
+)
+
+// maxSunSearchDays bounds the days searched for a solar event,
+// it covers the longest polar day or night.
+const maxSunSearchDays = 366
+
+// SunSchedule returns the schedule that fires at the solar event of each day
+// at the given coordinates, lat and lon are in degrees, north and east are positive.
+// The offset is added to the event time, e.g. 30 minutes after sunset.
+// The days without the event (polar days and nights) are skipped.
+// If loc is nil, the location of the time given to Next is used.
+func SunSchedule(lat, lon float64, event SunEvent, offset time.Duration, loc *time.Location) Schedule {
+	return &sunSchedule{
+		lat:    lat,
+		lon:    lon,
+		event:  event,
+		offset: offset,
+		loc:    loc,
+	}
+}
+
+type sunSchedule struct {
+	lat, lon float64
+	event    SunEvent
+	offset   time.Duration
+	loc      *time.Location
+}
+
+func (ss *sunSchedule) Next(t time.Time) time.Time {
+	loc := ss.loc
+	if loc == nil {
+		loc = t.Location()
+	}
+
+	lt := t.In(loc)
+	day := time.Date(lt.Year(), lt.Month(), lt.Day()-1, 12, 0, 0, 0, loc)
+	for i := 0; i <= maxSunSearchDays; i++ {
+		at, ok := ss.eventOf(day.AddDate(0, 0, i))
+		if !ok {
+			continue
+		}
+		if at = at.Add(ss.offset); at.After(t) {
+			return at.In(loc)
+		}
+	}
+	return time.Time{}
+}
+
+// eventOf returns the time of the event of the day,
+// it returns false if the sun does not rise or set on that day.
+// See https://en.wikipedia.org/wiki/Sunrise_equation
+func (ss *sunSchedule) eventOf(day time.Time) (time.Time, bool) {
+	const (
+		j2000    = 2451545.0 // Julian date of 2000-01-01 12:00 UTC
+		unixJD   = 2440587.5 // Julian date of the Unix epoch
+		toRad    = math.Pi / 180
+		tilt     = 23.4397 * toRad
+		altitude = -0.833 * toRad // refraction and solar disc
+	)
+
+	y, m, d := day.Date()
+	noon := time.Date(y, m, d, 12, 0, 0, 0, time.UTC)
+	n := math.Round(float64(noon.Unix())/86400 + unixJD - j2000)
+
+	// mean solar time
+	js := n - ss.lon/360
+	// solar mean anomaly
+	ma := math.Mod(357.5291+0.98560028*js, 360) * toRad
+	// equation of the center
+	c := 1.9148*math.Sin(ma) + 0.0200*math.Sin(2*ma) + 0.0003*math.Sin(3*ma)
+	// ecliptic longitude
+	el := math.Mod(ma/toRad+c+180+102.9372, 360) * toRad
+	// solar transit
+	transit := j2000 + js + 0.0053*math.Sin(ma) - 0.0069*math.Sin(2*el)
+	// declination of the sun
+	sinDecl := math.Sin(el) * math.Sin(tilt)
+	cosDecl := math.Cos(math.Asin(sinDecl))
+	// hour angle
+	phi := ss.lat * toRad
+	cosHA := (math.Sin(altitude) - math.Sin(phi)*sinDecl) / (math.Cos(phi) * cosDecl)
+	if cosHA < -1 || cosHA > 1 { // polar day or night
+		return time.Time{}, false
+	}
+
+	ha := math.Acos(cosHA) / toRad
+	jd := transit - ha/360
+	if ss.event == Sunset {
+		jd = transit + ha/360
+	}
+
+	secs := (jd - unixJD) * 86400
+	return time.Unix(0, int64(secs*float64(time.Second))), true
+}
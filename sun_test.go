@@ -0,0 +1,57 @@
+// Copyright (c) 2019,CAO HONGJU. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package scheduler
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSunSchedule(t *testing.T) {
+	bst := time.FixedZone("BST", 3600)
+	edt := time.FixedZone("EDT", -4*3600)
+	tests := []struct {
+		name     string
+		lat, lon float64
+		event    SunEvent
+		offset   time.Duration
+		from     time.Time
+		want     time.Time
+	}{
+		{"London sunrise", 51.5074, -0.1278, Sunrise, 0,
+			time.Date(2020, 6, 21, 0, 0, 0, 0, bst), time.Date(2020, 6, 21, 4, 43, 0, 0, bst)},
+		{"London sunset", 51.5074, -0.1278, Sunset, 0,
+			time.Date(2020, 6, 21, 0, 0, 0, 0, bst), time.Date(2020, 6, 21, 21, 21, 0, 0, bst)},
+		{"London sunset next day", 51.5074, -0.1278, Sunset, 0,
+			time.Date(2020, 6, 21, 22, 0, 0, 0, bst), time.Date(2020, 6, 22, 21, 21, 0, 0, bst)},
+		{"New York sunrise", 40.7128, -74.0060, Sunrise, 0,
+			time.Date(2020, 3, 20, 0, 0, 0, 0, edt), time.Date(2020, 3, 20, 6, 59, 0, 0, edt)},
+		{"New York after sunset", 40.7128, -74.0060, Sunset, 30 * time.Minute,
+			time.Date(2020, 3, 20, 0, 0, 0, 0, edt), time.Date(2020, 3, 20, 19, 39, 0, 0, edt)},
+	}
+
+	for _, test := range tests {
+		next := SunSchedule(test.lat, test.lon, test.event, test.offset, nil).Next(test.from)
+		diff := next.Sub(test.want)
+		assert.True(t, diff > -3*time.Minute && diff < 3*time.Minute,
+			"%s: want %v, got %v", test.name, test.want, next)
+	}
+}
+
+func TestSunSchedule_Polar(t *testing.T) {
+	// polar night in Tromsø, the sun rises again in mid January
+	from := time.Date(2020, 12, 1, 0, 0, 0, 0, time.UTC)
+	next := SunSchedule(69.6492, 18.9553, Sunrise, 0, time.UTC).Next(from)
+	assert.Equal(t, 2021, next.Year())
+	assert.Equal(t, time.January, next.Month())
+	assert.True(t, next.Day() > 10 && next.Day() < 20, next)
+
+	// the polar night near the north pole lasts until March
+	next = SunSchedule(89, 0, Sunrise, 0, time.UTC).Next(from)
+	assert.Equal(t, 2021, next.Year())
+	assert.Equal(t, time.March, next.Month())
+}
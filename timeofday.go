@@ -0,0 +1,83 @@
+// Copyright (c) 2019,CAO HONGJU. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package scheduler
+
+import (
+	"sort"
+	"time"
+)
+
+// maxTimeOfDayDays bounds the days searched by a time-of-day schedule.
+const maxTimeOfDayDays = 1 << 12
+
+// WithTimeOfDay returns the schedule that fires at the given clock times on each day
+// selected by dateSchedule, a day is selected if dateSchedule fires at any time in it.
+//
+// The i-th clock time is hours[i]:minutes[i]:seconds[i], minutes and seconds
+// may be shorter than hours, the missing values are 0. If any value is out of range,
+// the schedule never fires. If loc is nil, the location of the time given to Next is used.
+func WithTimeOfDay(dateSchedule Schedule, hours, minutes, seconds []int, loc *time.Location) Schedule {
+	if len(hours) == 0 || len(minutes) > len(hours) || len(seconds) > len(hours) {
+		return emptySchedule{}
+	}
+
+	times := make([]time.Duration, len(hours))
+	for i, hour := range hours {
+		var minute, second int
+		if i < len(minutes) {
+			minute = minutes[i]
+		}
+		if i < len(seconds) {
+			second = seconds[i]
+		}
+		if hour < 0 || hour > 23 || minute < 0 || minute > 59 || second < 0 || second > 59 {
+			return emptySchedule{}
+		}
+		times[i] = time.Duration(hour)*time.Hour + time.Duration(minute)*time.Minute +
+			time.Duration(second)*time.Second
+	}
+	sort.Slice(times, func(i, j int) bool { return times[i] < times[j] })
+
+	return &timeOfDaySchedule{
+		dates: dateSchedule,
+		times: times,
+		loc:   loc,
+	}
+}
+
+type timeOfDaySchedule struct {
+	dates Schedule
+	times []time.Duration // the clock times, in order
+	loc   *time.Location
+}
+
+func (tds *timeOfDaySchedule) Next(t time.Time) time.Time {
+	lt := t
+	if tds.loc != nil {
+		lt = t.In(tds.loc)
+	}
+	y, m, d := lt.Date()
+	day := time.Date(y, m, d, 0, 0, 0, 0, lt.Location())
+
+	for i := 0; i < maxTimeOfDayDays; i++ {
+		// the first day selected by dates from day
+		selected := tds.dates.Next(day.Add(-time.Nanosecond))
+		if selected.IsZero() {
+			return time.Time{}
+		}
+		y, m, d := selected.In(lt.Location()).Date()
+		day = time.Date(y, m, d, 0, 0, 0, 0, lt.Location())
+
+		for _, clock := range tds.times {
+			next := time.Date(y, m, d, int(clock/time.Hour), int(clock/time.Minute%60),
+				int(clock/time.Second%60), 0, lt.Location())
+			if next.After(t) {
+				return next
+			}
+		}
+		day = time.Date(y, m, d+1, 0, 0, 0, 0, lt.Location())
+	}
+	return time.Time{}
+}
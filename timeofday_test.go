@@ -0,0 +1,52 @@
+// Copyright (c) 2019,CAO HONGJU. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package scheduler
+
+import (
+	"testing"
+	"time"
+
+	"github.com/cnotch/scheduler/cron"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWithTimeOfDay(t *testing.T) {
+	layout := "Mon 2006-01-02 15:04"
+	weekdays := cron.MustParse("0 0 12 * * MON-FRI")
+	sched := WithTimeOfDay(weekdays, []int{13, 9}, []int{30}, nil, time.UTC)
+
+	tests := []struct {
+		from  string
+		nexts []string
+	}{
+		{"Fri 2020-04-24 10:00", []string{"Fri 2020-04-24 13:30", "Mon 2020-04-27 09:00", "Mon 2020-04-27 13:30", "Tue 2020-04-28 09:00"}},
+		{"Fri 2020-04-24 14:00", []string{"Mon 2020-04-27 09:00"}},
+		{"Sat 2020-04-25 08:00", []string{"Mon 2020-04-27 09:00"}},
+		{"Thu 2020-04-30 08:59", []string{"Thu 2020-04-30 09:00", "Thu 2020-04-30 13:30", "Fri 2020-05-01 09:00"}},
+	}
+	for _, test := range tests {
+		next, _ := time.Parse(layout, test.from)
+		for _, want := range test.nexts {
+			next = sched.Next(next)
+			assert.Equal(t, want, next.Format(layout), test.from)
+		}
+	}
+
+	// in the location
+	loc := time.FixedZone("UTC+8", 8*3600)
+	sched = WithTimeOfDay(cron.MustParse("0 0 0 * * MON-FRI"), []int{9}, nil, nil, loc)
+	from := time.Date(2020, 4, 24, 2, 0, 0, 0, time.UTC) // Fri 10:00 in loc
+	assert.Equal(t, time.Date(2020, 4, 27, 9, 0, 0, 0, loc), sched.Next(from))
+
+	// exhausted and invalid
+	once := cron.MustParse("0 0 0 24 4 * 2020")
+	sched = WithTimeOfDay(once, []int{9}, nil, nil, time.UTC)
+	assert.Equal(t, time.Date(2020, 4, 24, 9, 0, 0, 0, time.UTC), sched.Next(from))
+	assert.True(t, sched.Next(from.Add(8*time.Hour)).IsZero())
+	sched = WithTimeOfDay(weekdays, []int{24}, nil, nil, time.UTC)
+	assert.True(t, sched.Next(from).IsZero())
+	sched = WithTimeOfDay(weekdays, []int{9}, []int{0, 30}, nil, time.UTC)
+	assert.True(t, sched.Next(from).IsZero())
+}
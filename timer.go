@@ -0,0 +1,61 @@
+// Copyright (c) 2019,CAO HONGJU. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package scheduler
+
+import (
+	"sync"
+	"time"
+)
+
+// ScheduleTimer is a timer driven by a Schedule.
+// It sends each successive time of the schedule on its channel,
+// the channel is closed when the schedule is exhausted or the timer is stopped.
+type ScheduleTimer struct {
+	C    <-chan time.Time // The channel on which the schedule times are delivered.
+	stop chan struct{}
+	once sync.Once
+}
+
+// NewScheduleTimer returns a new ScheduleTimer that fires at the times
+// of the schedule after from.
+func NewScheduleTimer(s Schedule, from time.Time) *ScheduleTimer {
+	c := make(chan time.Time, 1)
+	t := &ScheduleTimer{
+		C:    c,
+		stop: make(chan struct{}),
+	}
+	go t.run(s, from, c)
+	return t
+}
+
+// Stop stops the timer and closes its channel.
+// It's safe to call Stop multiple times.
+func (t *ScheduleTimer) Stop() {
+	t.once.Do(func() {
+		close(t.stop)
+	})
+}
+
+func (t *ScheduleTimer) run(s Schedule, from time.Time, c chan time.Time) {
+	defer close(c)
+
+	next := s.Next(from)
+	for !next.IsZero() {
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-t.stop:
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+
+		select {
+		case <-t.stop:
+			return
+		case c <- next:
+		}
+		next = s.Next(next)
+	}
+}
@@ -0,0 +1,52 @@
+// Copyright (c) 2019,CAO HONGJU. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package scheduler
+
+import (
+	"testing"
+	"time"
+
+	"github.com/cnotch/scheduler/cron"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestScheduleTimer(t *testing.T) {
+	expr := cron.MustParse("* * * * * *")
+	timer := NewScheduleTimer(expr, time.Now())
+	defer timer.Stop()
+
+	var prev time.Time
+	for i := 0; i < 3; i++ {
+		select {
+		case <-time.After(oneSecond):
+			t.Fatal("expected timer fires")
+		case tick := <-timer.C:
+			assert.False(t, time.Now().Before(tick))
+			if !prev.IsZero() {
+				assert.Equal(t, time.Second, tick.Sub(prev))
+			}
+			prev = tick
+		}
+	}
+
+	timer.Stop()
+	timer.Stop() // multiple calls
+
+	// the channel is closed after stopped
+	for range timer.C {
+	}
+}
+
+func TestScheduleTimer_Exhausted(t *testing.T) {
+	now := time.Now()
+	timer := NewScheduleTimer(whSchedule{[]time.Time{now.Add(10 * time.Millisecond)}}, now)
+	defer timer.Stop()
+
+	tick, ok := <-timer.C
+	assert.True(t, ok)
+	assert.Equal(t, now.Add(10*time.Millisecond), tick)
+	_, ok = <-timer.C
+	assert.False(t, ok)
+}